@@ -0,0 +1,193 @@
+// Package admin exposes query-log retrieval, rolling stats, cache
+// flushing, and upstream hot-swapping over HTTP for operational use.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GauthamaSid/DNS_GOlang/cache"
+	"github.com/GauthamaSid/DNS_GOlang/querylog"
+)
+
+// Defaults used by /stats when the caller doesn't override them via query
+// parameters.
+const (
+	defaultStatsWindow = 1 * time.Hour
+	defaultStatsTopN   = 10
+)
+
+// SetUpstreamsFunc hot-swaps the running resolver's upstream servers. It's
+// supplied by main so this package doesn't need to depend on the DNS
+// handler type.
+type SetUpstreamsFunc func(urls []string) error
+
+// Server serves the admin HTTP API.
+type Server struct {
+	recorder     *querylog.Recorder
+	cache        cache.Cache
+	setUpstreams SetUpstreamsFunc
+	token        string
+	mux          *http.ServeMux
+}
+
+// NewServer wires up the admin HTTP handlers. When token is non-empty,
+// every request must present it as a "Bearer <token>" Authorization header;
+// this is the only thing standing between /config/upstream (which can
+// silently redirect all DNS resolution) and anyone who can reach the admin
+// address, so callers binding to anything beyond loopback should always set
+// one.
+func NewServer(recorder *querylog.Recorder, c cache.Cache, setUpstreams SetUpstreamsFunc, token string) *Server {
+	s := &Server{recorder: recorder, cache: c, setUpstreams: setUpstreams, token: token}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/querylog", s.authenticate(s.handleQueryLog))
+	s.mux.HandleFunc("/stats", s.authenticate(s.handleStats))
+	s.mux.HandleFunc("/cache/flush", s.authenticate(s.handleCacheFlush))
+	s.mux.HandleFunc("/config/upstream", s.authenticate(s.handleConfigUpstream))
+
+	return s
+}
+
+// authenticate wraps next with a shared-secret check. If no token is
+// configured, every request is let through unchanged, since a loopback-only
+// admin address is itself the access control in that case.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	if s.token == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(auth, prefix)
+}
+
+// ListenAndServe starts the admin HTTP server on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.mux)
+}
+
+func (s *Server) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f := querylog.Filter{
+		Client: r.URL.Query().Get("client"),
+		Domain: r.URL.Query().Get("domain"),
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+
+	writeJSON(w, s.recorder.GetRecent(f))
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := defaultStatsWindow
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	topN := defaultStatsTopN
+	if raw := r.URL.Query().Get("top"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "invalid top", http.StatusBadRequest)
+			return
+		}
+		topN = n
+	}
+
+	writeJSON(w, s.recorder.Stats(window, topN))
+}
+
+func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := s.cache.(cache.Flusher)
+	if !ok {
+		http.Error(w, "cache backend does not support flushing", http.StatusNotImplemented)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "prefix must not be empty", http.StatusBadRequest)
+		return
+	}
+
+	n, err := flusher.FlushPrefix(prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]int{"flushed": n})
+}
+
+func (s *Server) handleConfigUpstream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Upstreams []string `json:"upstreams"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.setUpstreams(body.Upstreams); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]int{"upstreams": len(body.Upstreams)})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GauthamaSid/DNS_GOlang/cache"
+	"github.com/GauthamaSid/DNS_GOlang/querylog"
+)
+
+func newTestRecorder(t *testing.T) *querylog.Recorder {
+	t.Helper()
+	r, err := querylog.NewRecorder(t.TempDir(), 100, 0)
+	if err != nil {
+		t.Fatalf("querylog.NewRecorder: %v", err)
+	}
+	return r
+}
+
+func TestServerRejectsRequestsWithoutToken(t *testing.T) {
+	s := NewServer(newTestRecorder(t), cache.NewLRUCache(10, cache.DefaultTTLBounds), nil, "secret")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestServerAcceptsRequestsWithCorrectToken(t *testing.T) {
+	s := NewServer(newTestRecorder(t), cache.NewLRUCache(10, cache.DefaultTTLBounds), nil, "secret")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestServerAllowsAnyRequestWhenNoTokenConfigured(t *testing.T) {
+	s := NewServer(newTestRecorder(t), cache.NewLRUCache(10, cache.DefaultTTLBounds), nil, "")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestHandleQueryLogFiltersByClient(t *testing.T) {
+	recorder := newTestRecorder(t)
+	recorder.Record(querylog.Entry{Timestamp: time.Now(), ClientIP: "192.0.2.1", QName: "a.example.com.", Source: querylog.SourceUpstream})
+	recorder.Record(querylog.Entry{Timestamp: time.Now(), ClientIP: "192.0.2.2", QName: "b.example.com.", Source: querylog.SourceUpstream})
+
+	s := NewServer(recorder, cache.NewLRUCache(10, cache.DefaultTTLBounds), nil, "")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/querylog?client=192.0.2.1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []querylog.Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ClientIP != "192.0.2.1" {
+		t.Fatalf("got %v, want a single entry for 192.0.2.1", entries)
+	}
+}
+
+func TestHandleCacheFlushReturnsFlushedCount(t *testing.T) {
+	c := cache.NewLRUCache(10, cache.DefaultTTLBounds)
+
+	s := NewServer(newTestRecorder(t), c, nil, "")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/cache/flush?prefix=dns:", "", nil)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body map[string]int
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if n, ok := body["flushed"]; !ok || n != 0 {
+		t.Fatalf("body = %v, want {\"flushed\": 0} for an empty cache", body)
+	}
+}
+
+func TestHandleCacheFlushRejectsEmptyPrefix(t *testing.T) {
+	c := cache.NewLRUCache(10, cache.DefaultTTLBounds)
+
+	s := NewServer(newTestRecorder(t), c, nil, "")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/cache/flush", "", nil)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandleConfigUpstreamCallsSetUpstreams(t *testing.T) {
+	var got []string
+	setUpstreams := func(urls []string) error {
+		got = urls
+		return nil
+	}
+
+	s := NewServer(newTestRecorder(t), cache.NewLRUCache(10, cache.DefaultTTLBounds), setUpstreams, "")
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/config/upstream", "application/json", strings.NewReader(`{"upstreams":["udp://1.1.1.1:53"]}`))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if len(got) != 1 || got[0] != "udp://1.1.1.1:53" {
+		t.Fatalf("setUpstreams called with %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+// Package cache stores DNS responses as packed wire-format messages so
+// repeated lookups return the original record structure with honest,
+// decremented TTLs instead of a flattened, fixed-TTL string blob.
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrMiss is returned by Get when key is absent or every RR in the cached
+// message has already expired.
+var ErrMiss = errors.New("cache: miss")
+
+// Cache stores and retrieves DNS messages. Put derives the entry's
+// lifetime itself from msg's answer TTLs (or, for negative responses, the
+// SOA minimum per RFC 2308), so callers never pass a TTL explicitly.
+type Cache interface {
+	// Get returns the cached reply for key with every RR's TTL decremented
+	// by the time elapsed since it was stored. It returns ErrMiss if key is
+	// absent or has fully expired.
+	Get(key string) (*dns.Msg, error)
+	// Put stores msg under key.
+	Put(key string, msg *dns.Msg)
+}
+
+// Key builds the cache key for a query, namespaced to avoid colliding with
+// unrelated keys when the backing store (e.g. Redis) is shared.
+func Key(qname string, qtype, qclass uint16) string {
+	return fmt.Sprintf("dns:%s:%d:%d", qname, qtype, qclass)
+}
+
+// KeyWithScope is Key with an EDNS Client Subnet scope appended, so answers
+// that vary by the client's network (see package ecs) aren't shared across
+// clients in different subnets. scope == "" behaves exactly like Key.
+func KeyWithScope(qname string, qtype, qclass uint16, scope string) string {
+	if scope == "" {
+		return Key(qname, qtype, qclass)
+	}
+	return fmt.Sprintf("%s:%s", Key(qname, qtype, qclass), scope)
+}
+
+// TTLBounds clamps how long a cache entry may live, separately for positive
+// and negative answers, so an operator can tune how aggressively stale
+// records are served versus how hard a popular name hammers upstream.
+type TTLBounds struct {
+	MinPositive time.Duration
+	MaxPositive time.Duration
+	MinNegative time.Duration
+	MaxNegative time.Duration
+}
+
+// DefaultTTLBounds are the clamps used unless overridden. RFC 2308
+// recommends a much shorter ceiling for negative answers than positive ones,
+// so a since-fixed record isn't missing for as long as a popular one stays
+// cached.
+var DefaultTTLBounds = TTLBounds{
+	MinPositive: 30 * time.Second,
+	MaxPositive: 24 * time.Hour,
+	MinNegative: 30 * time.Second,
+	MaxNegative: 10 * time.Minute,
+}
+
+// isNegative reports whether msg is a negative response: NXDOMAIN, or a
+// NOERROR reply with no answer (NODATA).
+func isNegative(msg *dns.Msg) bool {
+	return msg.Rcode == dns.RcodeNameError || (msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0)
+}
+
+// effectiveTTL derives how long msg should be cached for, clamped to bounds.
+func effectiveTTL(msg *dns.Msg, bounds TTLBounds) time.Duration {
+	if isNegative(msg) {
+		return negativeTTL(msg, bounds)
+	}
+	return positiveTTL(msg, bounds)
+}
+
+func positiveTTL(msg *dns.Msg, bounds TTLBounds) time.Duration {
+	var min uint32
+	has := false
+	for _, rr := range msg.Answer {
+		if !has || rr.Header().Ttl < min {
+			min = rr.Header().Ttl
+			has = true
+		}
+	}
+	if !has {
+		return bounds.MinPositive
+	}
+	return clamp(time.Duration(min)*time.Second, bounds.MinPositive, bounds.MaxPositive)
+}
+
+// negativeTTL implements the RFC 2308 rule: cache for the minimum of the
+// SOA RR's own TTL and its MINIMUM field, clamped to bounds.
+func negativeTTL(msg *dns.Msg, bounds TTLBounds) time.Duration {
+	for _, rr := range msg.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		ttl := soa.Minttl
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		return clamp(time.Duration(ttl)*time.Second, bounds.MinNegative, bounds.MaxNegative)
+	}
+	return bounds.MinNegative
+}
+
+func clamp(d, lo, hi time.Duration) time.Duration {
+	switch {
+	case d < lo:
+		return lo
+	case d > hi:
+		return hi
+	default:
+		return d
+	}
+}
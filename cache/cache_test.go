@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return rr
+}
+
+func TestLRUCacheDecrementsTTLOnGet(t *testing.T) {
+	c := NewLRUCache(10, DefaultTTLBounds)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{mustRR(t, "example.com. 10 IN A 192.0.2.1")}
+
+	key := Key("example.com.", dns.TypeA, dns.ClassINET)
+	c.Put(key, msg)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	got, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ttl := got.Answer[0].Header().Ttl; ttl >= 10 {
+		t.Fatalf("expected TTL to have decremented below 10, got %d", ttl)
+	}
+}
+
+func TestLRUCacheMissAfterExpiry(t *testing.T) {
+	c := NewLRUCache(10, DefaultTTLBounds)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{mustRR(t, "example.com. 1 IN A 192.0.2.1")}
+	// positiveTTL floors to DefaultTTLBounds.MinPositive (30s) regardless of the RR's own
+	// TTL, so the entry stays in the store; it's unwrap's own TTL math that
+	// must report it expired for a 1s record once enough time has passed.
+	msg.Answer[0].Header().Ttl = 1
+
+	key := Key("example.com.", dns.TypeA, dns.ClassINET)
+	c.Put(key, msg)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := c.Get(key); err != ErrMiss {
+		t.Fatalf("Get after TTL expiry: got err %v, want ErrMiss", err)
+	}
+}
+
+func TestLRUCacheHonorsCustomTTLBounds(t *testing.T) {
+	// A 1s MaxPositive should evict the entry from the store well before the
+	// RR's own 300s TTL would, unlike DefaultTTLBounds.MaxPositive (24h).
+	bounds := TTLBounds{MinPositive: time.Second, MaxPositive: time.Second, MinNegative: time.Second, MaxNegative: time.Second}
+	c := NewLRUCache(10, bounds)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{mustRR(t, "example.com. 300 IN A 192.0.2.1")}
+
+	key := Key("example.com.", dns.TypeA, dns.ClassINET)
+	c.Put(key, msg)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := c.Get(key); err != ErrMiss {
+		t.Fatalf("Get after custom MaxPositive elapsed: got err %v, want ErrMiss", err)
+	}
+}
+
+func TestIsNegative(t *testing.T) {
+	nxdomain := new(dns.Msg)
+	nxdomain.Rcode = dns.RcodeNameError
+	if !isNegative(nxdomain) {
+		t.Error("NXDOMAIN should be negative")
+	}
+
+	nodata := new(dns.Msg)
+	nodata.Rcode = dns.RcodeSuccess
+	if !isNegative(nodata) {
+		t.Error("NOERROR with no answer should be negative")
+	}
+
+	positive := new(dns.Msg)
+	positive.Rcode = dns.RcodeSuccess
+	positive.Answer = []dns.RR{mustRR(t, "example.com. 300 IN A 192.0.2.1")}
+	if isNegative(positive) {
+		t.Error("NOERROR with an answer should not be negative")
+	}
+}
+
+func TestFlushPrefix(t *testing.T) {
+	c := NewLRUCache(10, DefaultTTLBounds)
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{mustRR(t, "example.com. 300 IN A 192.0.2.1")}
+
+	c.Put(Key("a.example.com.", dns.TypeA, dns.ClassINET), msg)
+	c.Put(Key("b.example.com.", dns.TypeA, dns.ClassINET), msg)
+	c.Put(Key("a.other.com.", dns.TypeA, dns.ClassINET), msg)
+
+	n, err := c.FlushPrefix("dns:a.")
+	if err != nil {
+		t.Fatalf("FlushPrefix: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("FlushPrefix removed %d entries, want 2", n)
+	}
+
+	if _, err := c.Get(Key("b.example.com.", dns.TypeA, dns.ClassINET)); err != nil {
+		t.Fatalf("unrelated key should survive flush, got err %v", err)
+	}
+}
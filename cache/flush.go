@@ -0,0 +1,9 @@
+package cache
+
+// Flusher is implemented by Cache backends that can drop every entry whose
+// key starts with a prefix, backing the admin API's cache-flush endpoint.
+type Flusher interface {
+	// FlushPrefix deletes every cached entry whose key starts with prefix,
+	// returning how many were removed.
+	FlushPrefix(prefix string) (int, error)
+}
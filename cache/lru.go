@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lruEntry is the value stored in LRUCache's list and lookup map.
+type lruEntry struct {
+	key     string
+	payload []byte
+	expires time.Time
+	elem    *list.Element
+}
+
+// LRUCache is an in-memory Cache used as a fallback when Redis is
+// unavailable. It evicts the least-recently-used entry once it reaches
+// capacity, in addition to the usual per-entry TTL expiry.
+type LRUCache struct {
+	capacity  int
+	ttlBounds TTLBounds
+
+	mu      sync.Mutex
+	entries map[string]*lruEntry
+	order   *list.List
+}
+
+// NewLRUCache returns an LRUCache holding at most capacity entries, clamping
+// each entry's lifetime to ttlBounds.
+func NewLRUCache(capacity int, ttlBounds TTLBounds) *LRUCache {
+	return &LRUCache{
+		capacity:  capacity,
+		ttlBounds: ttlBounds,
+		entries:   make(map[string]*lruEntry),
+		order:     list.New(),
+	}
+}
+
+func (c *LRUCache) Get(key string) (*dns.Msg, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, ErrMiss
+	}
+	if time.Now().After(entry.expires) {
+		c.removeLocked(entry)
+		c.mu.Unlock()
+		return nil, ErrMiss
+	}
+	c.order.MoveToFront(entry.elem)
+	payload := entry.payload
+	c.mu.Unlock()
+
+	msg, fresh, err := unwrap(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrMiss
+	}
+	return msg, nil
+}
+
+func (c *LRUCache) Put(key string, msg *dns.Msg) {
+	buf, err := wrap(msg)
+	if err != nil {
+		return
+	}
+	expires := time.Now().Add(effectiveTTL(msg, c.ttlBounds))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.entries[key]; ok {
+		existing.payload = buf
+		existing.expires = expires
+		c.order.MoveToFront(existing.elem)
+		return
+	}
+
+	entry := &lruEntry{key: key, payload: buf, expires: expires}
+	entry.elem = c.order.PushFront(entry)
+	c.entries[key] = entry
+
+	if c.order.Len() > c.capacity {
+		if oldest := c.order.Back(); oldest != nil {
+			c.removeLocked(oldest.Value.(*lruEntry))
+		}
+	}
+}
+
+func (c *LRUCache) removeLocked(entry *lruEntry) {
+	c.order.Remove(entry.elem)
+	delete(c.entries, entry.key)
+}
+
+// FlushPrefix deletes every entry whose key starts with prefix.
+func (c *LRUCache) FlushPrefix(prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, entry := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeLocked(entry)
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// wrap packs msg alongside the current time so a later unwrap can work out
+// how much of each RR's TTL has elapsed since it was stored.
+func wrap(msg *dns.Msg) ([]byte, error) {
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("cache: packing message: %w", err)
+	}
+
+	buf := make([]byte, 8+len(packed))
+	binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().Unix()))
+	copy(buf[8:], packed)
+	return buf, nil
+}
+
+// unwrap reverses wrap, decrementing every Answer/Ns/Extra RR's TTL by the
+// time elapsed since storage. fresh is false once every RR in msg has
+// expired, signaling the caller should treat this as a cache miss.
+func unwrap(buf []byte) (msg *dns.Msg, fresh bool, err error) {
+	if len(buf) < 8 {
+		return nil, false, fmt.Errorf("cache: truncated entry")
+	}
+
+	storedAt := time.Unix(int64(binary.BigEndian.Uint64(buf[:8])), 0)
+
+	msg = new(dns.Msg)
+	if err := msg.Unpack(buf[8:]); err != nil {
+		return nil, false, fmt.Errorf("cache: unpacking message: %w", err)
+	}
+
+	elapsed := time.Since(storedAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	elapsedSecs := uint32(elapsed / time.Second)
+
+	for _, rrset := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range rrset {
+			hdr := rr.Header()
+			if hdr.Ttl <= elapsedSecs {
+				hdr.Ttl = 0
+				continue
+			}
+			hdr.Ttl -= elapsedSecs
+			fresh = true
+		}
+	}
+
+	// A message with no RRs at all (e.g. a cached NXDOMAIN with no SOA) is
+	// still fresh until the entry's own expiry removes it from the store.
+	if len(msg.Answer) == 0 && len(msg.Ns) == 0 && len(msg.Extra) == 0 {
+		fresh = true
+	}
+
+	return msg, fresh, nil
+}
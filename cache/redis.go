@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache stores packed DNS messages in Redis, relying on Redis key
+// expiration for eviction. Each entry embeds its store time so Get can
+// decrement RR TTLs by the time actually elapsed.
+type RedisCache struct {
+	client    *redis.Client
+	ttlBounds TTLBounds
+}
+
+// NewRedisCache wraps an existing Redis client as a Cache, clamping each
+// entry's lifetime to ttlBounds.
+func NewRedisCache(client *redis.Client, ttlBounds TTLBounds) *RedisCache {
+	return &RedisCache{client: client, ttlBounds: ttlBounds}
+}
+
+func (c *RedisCache) Get(key string) (*dns.Msg, error) {
+	raw, err := c.client.Get(context.Background(), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cache: redis get %q: %w", key, err)
+	}
+
+	msg, fresh, err := unwrap(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrMiss
+	}
+	return msg, nil
+}
+
+func (c *RedisCache) Put(key string, msg *dns.Msg) {
+	buf, err := wrap(msg)
+	if err != nil {
+		log.Printf("cache: failed to pack %q for Redis: %v\n", key, err)
+		return
+	}
+
+	if err := c.client.Set(context.Background(), key, buf, effectiveTTL(msg, c.ttlBounds)).Err(); err != nil {
+		log.Printf("cache: failed to store %q in Redis: %v\n", key, err)
+	}
+}
+
+// FlushPrefix deletes every Redis key starting with prefix via SCAN, so it
+// never blocks the server the way KEYS would on a large keyspace.
+func (c *RedisCache) FlushPrefix(prefix string) (int, error) {
+	ctx := context.Background()
+	var cursor uint64
+	deleted := 0
+
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, prefix+"*", 100).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("cache: scanning %q: %w", prefix, err)
+		}
+		if len(keys) > 0 {
+			n, err := c.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("cache: deleting keys: %w", err)
+			}
+			deleted += int(n)
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,50 @@
+// Package ecs builds the EDNS0 Client Subnet (ECS) option (RFC 7871) used
+// to forward a prefix-trimmed client address to upstreams that tailor
+// answers by network location.
+package ecs
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Default prefix lengths forwarded upstream: enough to identify a general
+// network without sharing the client's full address.
+const (
+	DefaultIPv4PrefixLength = 24
+	DefaultIPv6PrefixLength = 56
+)
+
+const (
+	familyIPv4 = 1
+	familyIPv6 = 2
+)
+
+// Subnet builds the EDNS0_SUBNET option for clientIP, truncated to
+// DefaultIPv4PrefixLength/DefaultIPv6PrefixLength bits. It returns nil if
+// clientIP is nil or isn't a valid IPv4/IPv6 address.
+func Subnet(clientIP net.IP) *dns.EDNS0_SUBNET {
+	if clientIP == nil {
+		return nil
+	}
+
+	if v4 := clientIP.To4(); v4 != nil {
+		return build(v4, familyIPv4, DefaultIPv4PrefixLength)
+	}
+	if v6 := clientIP.To16(); v6 != nil {
+		return build(v6, familyIPv6, DefaultIPv6PrefixLength)
+	}
+	return nil
+}
+
+func build(ip net.IP, family uint16, prefixLen uint8) *dns.EDNS0_SUBNET {
+	mask := net.CIDRMask(int(prefixLen), len(ip)*8)
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: prefixLen,
+		SourceScope:   0,
+		Address:       ip.Mask(mask),
+	}
+}
@@ -0,0 +1,53 @@
+package ecs
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSubnetIPv4TruncatesToDefaultPrefix(t *testing.T) {
+	opt := Subnet(net.ParseIP("203.0.113.42"))
+	if opt == nil {
+		t.Fatal("Subnet returned nil for a valid IPv4 address")
+	}
+	if opt.Family != familyIPv4 {
+		t.Errorf("Family = %d, want %d", opt.Family, familyIPv4)
+	}
+	if opt.SourceNetmask != DefaultIPv4PrefixLength {
+		t.Errorf("SourceNetmask = %d, want %d", opt.SourceNetmask, DefaultIPv4PrefixLength)
+	}
+	if got, want := opt.Address.String(), "203.0.113.0"; got != want {
+		t.Errorf("Address = %s, want %s (host bits beyond /24 should be masked off)", got, want)
+	}
+	if opt.SourceScope != 0 {
+		t.Errorf("SourceScope = %d, want 0", opt.SourceScope)
+	}
+}
+
+func TestSubnetIPv6TruncatesToDefaultPrefix(t *testing.T) {
+	opt := Subnet(net.ParseIP("2001:db8:abcd:1234::1"))
+	if opt == nil {
+		t.Fatal("Subnet returned nil for a valid IPv6 address")
+	}
+	if opt.Family != familyIPv6 {
+		t.Errorf("Family = %d, want %d", opt.Family, familyIPv6)
+	}
+	if opt.SourceNetmask != DefaultIPv6PrefixLength {
+		t.Errorf("SourceNetmask = %d, want %d", opt.SourceNetmask, DefaultIPv6PrefixLength)
+	}
+}
+
+func TestSubnetNilForNilIP(t *testing.T) {
+	if opt := Subnet(nil); opt != nil {
+		t.Errorf("Subnet(nil) = %v, want nil", opt)
+	}
+}
+
+func TestSubnetIsValidEDNS0Option(t *testing.T) {
+	opt := Subnet(net.ParseIP("198.51.100.7"))
+	if opt.Code != dns.EDNS0SUBNET {
+		t.Errorf("Code = %d, want %d", opt.Code, dns.EDNS0SUBNET)
+	}
+}
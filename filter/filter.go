@@ -0,0 +1,124 @@
+// Package filter blocks or rewrites DNS answers for query names matched
+// against hosts-file, plain-hostname, and AdGuard/uBlock-style blocklists.
+package filter
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Mode controls how a blocked query is answered.
+type Mode int
+
+const (
+	// ModeNXDOMAIN answers a blocked query with NXDOMAIN.
+	ModeNXDOMAIN Mode = iota
+	// ModeNull answers with 0.0.0.0 (A) or :: (AAAA) instead of the real
+	// records.
+	ModeNull
+	// ModeRefused answers a blocked query with REFUSED.
+	ModeRefused
+)
+
+// Decision reports whether a queried name was blocked and, if so, which
+// rule matched and how the query should be answered.
+type Decision struct {
+	Blocked bool
+	Rule    string
+	Mode    Mode
+}
+
+// Filter matches query names against blocklist sources refreshed on an
+// interval, with an allowlist that always takes precedence over a block
+// match.
+type Filter struct {
+	mode            Mode
+	blockSources    []Source
+	allowSources    []Source
+	refreshInterval time.Duration
+
+	mu      sync.RWMutex
+	blocked *trie
+	allowed *trie
+}
+
+// NewFilter builds a Filter that blocks names matched by blockSources
+// (answered per mode) unless allowSources also match, refreshing both
+// lists every refreshInterval. The first load happens synchronously so the
+// Filter is ready to use as soon as NewFilter returns; refreshInterval <= 0
+// disables the periodic refresh.
+func NewFilter(blockSources, allowSources []Source, mode Mode, refreshInterval time.Duration) *Filter {
+	f := &Filter{
+		mode:            mode,
+		blockSources:    blockSources,
+		allowSources:    allowSources,
+		refreshInterval: refreshInterval,
+		blocked:         newTrie(),
+		allowed:         newTrie(),
+	}
+
+	f.refresh()
+	if refreshInterval > 0 {
+		go f.refreshLoop()
+	}
+
+	return f
+}
+
+func (f *Filter) refreshLoop() {
+	ticker := time.NewTicker(f.refreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		f.refresh()
+	}
+}
+
+func (f *Filter) refresh() {
+	blocked := newTrie()
+	for _, src := range f.blockSources {
+		domains, err := src.fetch()
+		if err != nil {
+			log.Printf("filter: %v\n", err)
+			continue
+		}
+		for _, d := range domains {
+			blocked.insert(d, src.Location)
+		}
+	}
+
+	allowed := newTrie()
+	for _, src := range f.allowSources {
+		domains, err := src.fetch()
+		if err != nil {
+			log.Printf("filter: %v\n", err)
+			continue
+		}
+		for _, d := range domains {
+			allowed.insert(d, src.Location)
+		}
+	}
+
+	f.mu.Lock()
+	f.blocked = blocked
+	f.allowed = allowed
+	f.mu.Unlock()
+
+	log.Printf("filter: refreshed blocklists (%d block sources, %d allow sources)\n", len(f.blockSources), len(f.allowSources))
+}
+
+// Check matches name against the filter's allow and block lists.
+func (f *Filter) Check(name string) Decision {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if _, ok := f.allowed.match(name); ok {
+		return Decision{Blocked: false}
+	}
+
+	if rule, ok := f.blocked.match(name); ok {
+		return Decision{Blocked: true, Rule: rule, Mode: f.mode}
+	}
+
+	return Decision{Blocked: false}
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListFormats(t *testing.T) {
+	input := `
+# comment
+! also a comment
+
+ads.example.com
+0.0.0.0 tracker.example.com analytics.example.com
+||doubleclick.net^$third-party
+`
+	got := parseList(strings.NewReader(input))
+	want := []string{"ads.example.com", "tracker.example.com", "analytics.example.com", "doubleclick.net"}
+
+	if len(got) != len(want) {
+		t.Fatalf("parseList() = %v, want %v", got, want)
+	}
+	for i, d := range want {
+		if got[i] != d {
+			t.Errorf("domain %d = %q, want %q", i, got[i], d)
+		}
+	}
+}
+
+func TestSourceFetchFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocklist.txt")
+	if err := os.WriteFile(path, []byte("blocked.example.com\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	src := Source{Location: path}
+	domains, err := src.fetch()
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(domains) != 1 || domains[0] != "blocked.example.com" {
+		t.Fatalf("fetch() = %v, want [blocked.example.com]", domains)
+	}
+}
+
+func TestFilterCheckBlocksSubdomainsAndRecordsRule(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blocklist.txt")
+	if err := os.WriteFile(path, []byte("ads.example.com\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	f := NewFilter([]Source{{Location: path}}, nil, ModeNXDOMAIN, 0)
+
+	decision := f.Check("sub.ads.example.com")
+	if !decision.Blocked {
+		t.Fatal("expected sub.ads.example.com to be blocked")
+	}
+	if decision.Rule != path {
+		t.Errorf("decision.Rule = %q, want %q", decision.Rule, path)
+	}
+
+	if f.Check("unrelated.example.com").Blocked {
+		t.Error("unrelated.example.com should not be blocked")
+	}
+}
+
+func TestFilterAllowlistOverridesBlocklist(t *testing.T) {
+	blockDir := t.TempDir()
+	blockPath := filepath.Join(blockDir, "block.txt")
+	os.WriteFile(blockPath, []byte("example.com\n"), 0o644)
+
+	allowDir := t.TempDir()
+	allowPath := filepath.Join(allowDir, "allow.txt")
+	os.WriteFile(allowPath, []byte("good.example.com\n"), 0o644)
+
+	f := NewFilter([]Source{{Location: blockPath}}, []Source{{Location: allowPath}}, ModeNXDOMAIN, 0)
+
+	if f.Check("good.example.com").Blocked {
+		t.Error("good.example.com is allowlisted and should not be blocked")
+	}
+	if !f.Check("bad.example.com").Blocked {
+		t.Error("bad.example.com should still be blocked")
+	}
+}
@@ -0,0 +1,46 @@
+package filter
+
+import "net"
+
+// Manager maps a client's source IP to the Filter that applies to its
+// queries, so different LAN devices can be given different filtering
+// policies.
+type Manager struct {
+	defaultFilter *Filter
+	scopes        []scope
+}
+
+type scope struct {
+	cidr   *net.IPNet
+	filter *Filter
+}
+
+// NewManager returns a Manager that applies defaultFilter to any client IP
+// not covered by a scope added via AddScope. defaultFilter may be nil, in
+// which case For returns nil for unscoped clients and no filtering happens.
+func NewManager(defaultFilter *Filter) *Manager {
+	return &Manager{defaultFilter: defaultFilter}
+}
+
+// AddScope applies filter to every client address within cidr. Scopes are
+// checked most-recently-added first, so register more specific CIDRs after
+// broader ones.
+func (m *Manager) AddScope(cidr string, filter *Filter) error {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	m.scopes = append(m.scopes, scope{cidr: ipnet, filter: filter})
+	return nil
+}
+
+// For returns the Filter that applies to clientIP, falling back to the
+// Manager's default filter if no scope matches.
+func (m *Manager) For(clientIP net.IP) *Filter {
+	for i := len(m.scopes) - 1; i >= 0; i-- {
+		if m.scopes[i].cidr.Contains(clientIP) {
+			return m.scopes[i].filter
+		}
+	}
+	return m.defaultFilter
+}
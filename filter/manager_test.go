@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"net"
+	"testing"
+)
+
+func TestManagerForFallsBackToDefault(t *testing.T) {
+	def := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	m := NewManager(def)
+
+	if got := m.For(net.ParseIP("203.0.113.1")); got != def {
+		t.Fatalf("For() = %v, want the default filter", got)
+	}
+}
+
+func TestManagerForMatchesScope(t *testing.T) {
+	def := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	scoped := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	m := NewManager(def)
+
+	if err := m.AddScope("192.168.1.0/24", scoped); err != nil {
+		t.Fatalf("AddScope: %v", err)
+	}
+
+	if got := m.For(net.ParseIP("192.168.1.42")); got != scoped {
+		t.Fatalf("For(192.168.1.42) = %v, want the scoped filter", got)
+	}
+	if got := m.For(net.ParseIP("192.168.2.1")); got != def {
+		t.Fatalf("For(192.168.2.1) = %v, want the default filter", got)
+	}
+}
+
+func TestManagerForChecksMostRecentlyAddedScopeFirst(t *testing.T) {
+	def := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	broad := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	narrow := NewFilter(nil, nil, ModeNXDOMAIN, 0)
+	m := NewManager(def)
+
+	if err := m.AddScope("192.168.0.0/16", broad); err != nil {
+		t.Fatalf("AddScope(broad): %v", err)
+	}
+	if err := m.AddScope("192.168.1.0/24", narrow); err != nil {
+		t.Fatalf("AddScope(narrow): %v", err)
+	}
+
+	if got := m.For(net.ParseIP("192.168.1.42")); got != narrow {
+		t.Fatalf("For(192.168.1.42) = %v, want the more specific, more-recently-added scope", got)
+	}
+	if got := m.For(net.ParseIP("192.168.2.1")); got != broad {
+		t.Fatalf("For(192.168.2.1) = %v, want the broader scope", got)
+	}
+}
+
+func TestManagerAddScopeRejectsInvalidCIDR(t *testing.T) {
+	m := NewManager(nil)
+	if err := m.AddScope("not-a-cidr", nil); err == nil {
+		t.Fatal("AddScope with an invalid CIDR should return an error")
+	}
+}
@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+)
+
+// parseList reads one blocklist from r, auto-detecting each line's format:
+// plain hostnames, /etc/hosts entries, or AdGuard/uBlock "||domain^" rules.
+// The three syntaxes don't overlap, so per-line detection needs no
+// upfront format hint. Blank lines and "#"/"!" comments are skipped.
+func parseList(r io.Reader) []string {
+	var domains []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "||"):
+			if d := parseAdBlockRule(line); d != "" {
+				domains = append(domains, d)
+			}
+		case looksLikeHostsLine(line):
+			domains = append(domains, parseHostsLine(line)...)
+		default:
+			domains = append(domains, normalizeDomain(line))
+		}
+	}
+
+	return domains
+}
+
+// parseAdBlockRule extracts the domain from a "||domain^" rule, ignoring
+// any trailing $-options or path component. Only "||" anchors reach it;
+// parseList has no handling for cosmetic rules (##, #@#) or other AdBlock
+// syntax, and takes such lines as plain hostnames.
+func parseAdBlockRule(line string) string {
+	rest := strings.TrimPrefix(line, "||")
+	if end := strings.IndexAny(rest, "^$/"); end != -1 {
+		rest = rest[:end]
+	}
+	return normalizeDomain(rest)
+}
+
+// looksLikeHostsLine reports whether line is an /etc/hosts entry: an IP
+// address followed by one or more hostnames.
+func looksLikeHostsLine(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) >= 2 && net.ParseIP(fields[0]) != nil
+}
+
+// parseHostsLine extracts every hostname from an /etc/hosts-format line,
+// which may list several aliases after the address.
+func parseHostsLine(line string) []string {
+	fields := strings.Fields(line)
+	domains := make([]string, 0, len(fields)-1)
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "#") {
+			break
+		}
+		domains = append(domains, normalizeDomain(f))
+	}
+	return domains
+}
+
+func normalizeDomain(s string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+}
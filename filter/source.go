@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sourceFetchTimeout bounds how long fetching a single HTTP blocklist may
+// take before Source.fetch gives up on it.
+const sourceFetchTimeout = 30 * time.Second
+
+// Source is a blocklist origin: a local file path or an http(s) URL.
+type Source struct {
+	Location string
+}
+
+// fetch downloads or reads Location and returns the domains it lists.
+func (s Source) fetch() ([]string, error) {
+	if strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://") {
+		return s.fetchHTTP()
+	}
+	return s.fetchFile()
+}
+
+func (s Source) fetchHTTP() ([]string, error) {
+	client := &http.Client{Timeout: sourceFetchTimeout}
+
+	resp, err := client.Get(s.Location)
+	if err != nil {
+		return nil, fmt.Errorf("filter: fetching %s: %w", s.Location, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("filter: fetching %s: unexpected status %d", s.Location, resp.StatusCode)
+	}
+
+	return parseList(resp.Body), nil
+}
+
+func (s Source) fetchFile() ([]string, error) {
+	f, err := os.Open(s.Location)
+	if err != nil {
+		return nil, fmt.Errorf("filter: opening %s: %w", s.Location, err)
+	}
+	defer f.Close()
+
+	return parseList(f), nil
+}
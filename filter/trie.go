@@ -0,0 +1,70 @@
+package filter
+
+import "strings"
+
+// trieNode is one label of a reversed-domain trie: "ads.example.com." is
+// stored along the path com -> example -> ads, so every subdomain of a
+// blocked domain walks through its terminal node and is matched in
+// O(labels in the queried name).
+type trieNode struct {
+	children map[string]*trieNode
+	terminal bool
+	rule     string
+}
+
+type trie struct {
+	root *trieNode
+}
+
+func newTrie() *trie {
+	return &trie{root: &trieNode{children: make(map[string]*trieNode)}}
+}
+
+// insert adds domain, and implicitly every name under it, to the trie.
+// rule is recorded on the terminal node for attribution at match time.
+func (t *trie) insert(domain, rule string) {
+	labels := splitLabels(domain)
+	if len(labels) == 0 {
+		return
+	}
+
+	node := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := labels[i]
+		child, ok := node.children[label]
+		if !ok {
+			child = &trieNode{children: make(map[string]*trieNode)}
+			node.children[label] = child
+		}
+		node = child
+	}
+	node.terminal = true
+	node.rule = rule
+}
+
+// match walks name's labels from the root label down and reports the rule
+// of the shallowest terminal node it passes, so a rule for "example.com"
+// also matches "ads.example.com".
+func (t *trie) match(name string) (rule string, ok bool) {
+	labels := splitLabels(name)
+	node := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, exists := node.children[labels[i]]
+		if !exists {
+			return "", false
+		}
+		node = child
+		if node.terminal {
+			return node.rule, true
+		}
+	}
+	return "", false
+}
+
+func splitLabels(name string) []string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
@@ -12,16 +13,280 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/redis/go-redis/v9"
+
+	"github.com/GauthamaSid/DNS_GOlang/admin"
+	"github.com/GauthamaSid/DNS_GOlang/cache"
+	"github.com/GauthamaSid/DNS_GOlang/ecs"
+	"github.com/GauthamaSid/DNS_GOlang/filter"
+	"github.com/GauthamaSid/DNS_GOlang/querylog"
+	"github.com/GauthamaSid/DNS_GOlang/ratelimit"
+	"github.com/GauthamaSid/DNS_GOlang/upstream"
 )
 
-// Redis client for caching DNS records
+// Redis client backing the cache.
 var redisClient *redis.Client
 
-// Cache expiration time for records in Redis
-const redisCacheTTL = 5 * time.Minute
+// lruCacheFallbackCapacity bounds the in-memory cache used when Redis is
+// unreachable at startup.
+const lruCacheFallbackCapacity = 10000
+
+// defaultBootstrapDNSServer resolves the hostnames in tls://, https://, and
+// quic:// upstream URLs before any encrypted transport can be dialed.
+const defaultBootstrapDNSServer = "8.8.8.8:53"
+
+// defaultMaxCNAMEChainDepth caps how many CNAME hops resolve will follow for
+// a single query, matching the chain limits used by typical recursive
+// resolvers.
+const defaultMaxCNAMEChainDepth = 8
+
+// defaultUpstreams are used when no upstreams are configured on startup.
+var defaultUpstreams = []string{"udp://8.8.8.8:53", "udp://1.1.1.1:53"}
+
+// defaultFilterRefreshInterval controls how often blocklists are re-fetched.
+const defaultFilterRefreshInterval = 1 * time.Hour
+
+// Query log and admin API settings.
+const (
+	defaultQueryLogDir       = "./querylog"
+	queryLogRingCapacity     = 10000
+	defaultQueryLogRetention = 7 * 24 * time.Hour
+	// defaultAdminAddr binds loopback-only: the admin API can flush the
+	// cache and redirect all DNS resolution, so it must not be exposed
+	// beyond the local host without an explicit -admin-addr and
+	// -admin-token override.
+	defaultAdminAddr = "127.0.0.1:8080"
+)
+
+// Rate limiting defaults; off unless enabled, since a single client (or a
+// spoofed flood) could otherwise monopolize the resolver.
+const (
+	defaultRateLimitQPS   = ratelimit.DefaultQPS
+	defaultRateLimitBurst = ratelimit.DefaultBurst
+	rateLimitGCInterval   = 5 * time.Minute
+	rateLimitIdleTTL      = 10 * time.Minute
+)
+
+// defaultRateLimitAllowCIDRs exempts local/private networks from rate
+// limiting so LAN clients behind the same resolver aren't throttled.
+var defaultRateLimitAllowCIDRs = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+}
+
+// config holds every runtime-configurable setting the server reads at
+// startup, populated by parseFlags so that upstreams, feature toggles, and
+// filter/rate-limit behavior can be changed without a rebuild.
+type config struct {
+	upstreams     []string
+	strategy      upstream.Strategy
+	maxCNAMEDepth int
+	bootstrapDNS  string
+	port          int
+
+	adminAddr         string
+	adminToken        string
+	queryLogDir       string
+	queryLogRetention time.Duration
+
+	// enableECS controls whether EDNS0 Client Subnet (see package ecs) is
+	// attached to upstream queries; sharing part of the client's address
+	// with upstream resolvers is opt-in.
+	enableECS bool
+
+	enableRateLimit     bool
+	rateLimitQPS        int
+	rateLimitBurst      int
+	rateLimitAllowCIDRs []string
+	// rateLimitTCOnExceeded, if true, answers a rate-limited query with
+	// SERVFAIL+TC (forcing a TCP retry) instead of plain REFUSED; TCP
+	// requires a completed handshake, which a spoofed-source UDP flood
+	// can't follow.
+	rateLimitTCOnExceeded bool
+
+	// enableRefuseAny short-circuits ANY queries with NotImplemented
+	// instead of answering them, since a large ANY response is a common
+	// reflection/amplification vector.
+	enableRefuseAny bool
+
+	blockSources []filter.Source
+	// allowSources always takes precedence over blockSources, e.g. to
+	// unblock a domain a blocklist flags as a false positive.
+	allowSources          []filter.Source
+	filterMode            filter.Mode
+	filterRefreshInterval time.Duration
+	// filterScopes lets different client CIDRs get their own block/allow
+	// sources on top of blockSources/allowSources, e.g. a stricter list for
+	// kids' devices.
+	filterScopes []filterScopeConfig
+
+	cacheTTLBounds cache.TTLBounds
+}
+
+// filterScopeConfig is one -filter-scope entry: the block and allow sources
+// to apply to clients within cidr.
+type filterScopeConfig struct {
+	cidr         string
+	blockSources []filter.Source
+	allowSources []filter.Source
+}
+
+// parseFlags builds a config from command-line flags.
+func parseFlags() config {
+	var cfg config
+	var upstreamsFlag, strategyFlag, allowCIDRsFlag, blockSourcesFlag, allowSourcesFlag, filterModeFlag, filterScopesFlag string
+
+	flag.StringVar(&upstreamsFlag, "upstreams", strings.Join(defaultUpstreams, ","),
+		"comma-separated upstream URLs (udp://, tcp://, tls://, https://, quic://)")
+	flag.StringVar(&strategyFlag, "strategy", "round-robin", "upstream selection strategy: round-robin or parallel")
+	flag.IntVar(&cfg.maxCNAMEDepth, "max-cname-depth", defaultMaxCNAMEChainDepth, "maximum CNAME chain hops to follow")
+	flag.StringVar(&cfg.bootstrapDNS, "bootstrap-dns", defaultBootstrapDNSServer,
+		"plain DNS server used to resolve tls/https/quic upstream hostnames")
+	flag.IntVar(&cfg.port, "port", 53, "UDP/TCP port to listen on")
+
+	flag.StringVar(&cfg.adminAddr, "admin-addr", defaultAdminAddr, "address for the admin HTTP API")
+	flag.StringVar(&cfg.adminToken, "admin-token", "", "shared secret required as a Bearer token on admin API requests; required if -admin-addr binds beyond loopback")
+	flag.StringVar(&cfg.queryLogDir, "query-log-dir", defaultQueryLogDir, "directory for the structured query log")
+	flag.DurationVar(&cfg.queryLogRetention, "query-log-retention", defaultQueryLogRetention,
+		"how long to retain query log entries on disk")
+
+	flag.BoolVar(&cfg.enableECS, "enable-ecs", false, "attach EDNS0 Client Subnet to upstream queries")
+
+	flag.BoolVar(&cfg.enableRateLimit, "enable-rate-limit", false, "enforce per-client rate limiting")
+	flag.IntVar(&cfg.rateLimitQPS, "rate-limit-qps", defaultRateLimitQPS, "sustained queries/sec allowed per client")
+	flag.IntVar(&cfg.rateLimitBurst, "rate-limit-burst", defaultRateLimitBurst, "burst size allowed per client")
+	flag.StringVar(&allowCIDRsFlag, "rate-limit-allow-cidrs", strings.Join(defaultRateLimitAllowCIDRs, ","),
+		"comma-separated CIDRs exempt from rate limiting")
+	flag.BoolVar(&cfg.rateLimitTCOnExceeded, "rate-limit-tc-on-exceeded", false,
+		"answer rate-limited queries with SERVFAIL+TC instead of REFUSED")
 
-// Default upstream DNS server
-const defaultUpstreamDNSServer = "8.8.8.8:53"
+	flag.BoolVar(&cfg.enableRefuseAny, "enable-refuse-any", false, "refuse ANY queries with NotImplemented")
+
+	flag.StringVar(&blockSourcesFlag, "block-sources", "", "comma-separated hosts-file/AdBlock blocklist paths or URLs")
+	flag.StringVar(&allowSourcesFlag, "allow-sources", "",
+		"comma-separated hosts-file/AdBlock allowlist paths or URLs; a match here always overrides -block-sources")
+	flag.StringVar(&filterModeFlag, "filter-mode", "nxdomain", "how a blocked query is answered: nxdomain, null, or refused")
+	flag.DurationVar(&cfg.filterRefreshInterval, "filter-refresh-interval", defaultFilterRefreshInterval,
+		"how often blocklists are re-fetched")
+	flag.StringVar(&filterScopesFlag, "filter-scope", "",
+		"semicolon-separated per-client-CIDR filter scopes, each cidr:comma-separated-block-sources[:comma-separated-allow-sources] "+
+			"(e.g. \"192.168.1.0/24:/etc/kids-block.txt;10.0.0.0/8:https://example.com/list.txt:https://example.com/allow.txt\")")
+
+	flag.DurationVar(&cfg.cacheTTLBounds.MinPositive, "cache-min-positive-ttl", cache.DefaultTTLBounds.MinPositive,
+		"shortest time a successful answer is cached for")
+	flag.DurationVar(&cfg.cacheTTLBounds.MaxPositive, "cache-max-positive-ttl", cache.DefaultTTLBounds.MaxPositive,
+		"longest time a successful answer is cached for")
+	flag.DurationVar(&cfg.cacheTTLBounds.MinNegative, "cache-min-negative-ttl", cache.DefaultTTLBounds.MinNegative,
+		"shortest time an NXDOMAIN/NODATA answer is cached for")
+	flag.DurationVar(&cfg.cacheTTLBounds.MaxNegative, "cache-max-negative-ttl", cache.DefaultTTLBounds.MaxNegative,
+		"longest time an NXDOMAIN/NODATA answer is cached for")
+
+	flag.Parse()
+
+	cfg.upstreams = splitNonEmpty(upstreamsFlag)
+	cfg.strategy = parseStrategy(strategyFlag)
+	cfg.rateLimitAllowCIDRs = splitNonEmpty(allowCIDRsFlag)
+	cfg.blockSources = parseSourceList(blockSourcesFlag)
+	cfg.allowSources = parseSourceList(allowSourcesFlag)
+	cfg.filterMode = parseFilterMode(filterModeFlag)
+	cfg.filterScopes = parseFilterScopes(filterScopesFlag)
+
+	if cfg.adminToken == "" && !isLoopbackAddr(cfg.adminAddr) {
+		log.Fatalf("admin API bound to %q without -admin-token; refusing to expose an unauthenticated cache-flush/upstream-redirect/query-log endpoint beyond loopback", cfg.adminAddr)
+	}
+
+	return cfg
+}
+
+// isLoopbackAddr reports whether addr's host resolves only to loopback,
+// e.g. "127.0.0.1:8080" or "localhost:8080". An empty host, as in ":8080",
+// binds every interface and is never considered loopback.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// splitNonEmpty splits s on commas, trims each part, and drops empty ones;
+// it returns nil for an empty s instead of a one-element slice.
+func splitNonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func parseStrategy(s string) upstream.Strategy {
+	if s == "parallel" {
+		return upstream.Parallel
+	}
+	return upstream.RoundRobin
+}
+
+func parseSourceList(s string) []filter.Source {
+	locations := splitNonEmpty(s)
+	sources := make([]filter.Source, 0, len(locations))
+	for _, loc := range locations {
+		sources = append(sources, filter.Source{Location: loc})
+	}
+	return sources
+}
+
+func parseFilterMode(s string) filter.Mode {
+	switch s {
+	case "null":
+		return filter.ModeNull
+	case "refused":
+		return filter.ModeRefused
+	default:
+		return filter.ModeNXDOMAIN
+	}
+}
+
+// parseFilterScopes parses a -filter-scope flag value: semicolon-separated
+// entries, each "cidr:comma-separated-block-sources[:comma-separated-allow-sources]".
+// A ':' that begins "://" belongs to a URL scheme and is not a separator.
+// Malformed entries are logged and skipped rather than aborting startup.
+func parseFilterScopes(s string) []filterScopeConfig {
+	if s == "" {
+		return nil
+	}
+	var scopes []filterScopeConfig
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		const schemeSep = "://"
+		parts := strings.SplitN(strings.ReplaceAll(entry, schemeSep, "\x00"), ":", 3)
+		for i := range parts {
+			parts[i] = strings.ReplaceAll(parts[i], "\x00", schemeSep)
+		}
+		if len(parts) < 2 {
+			log.Printf("filter: ignoring malformed -filter-scope entry %q (want cidr:block-sources[:allow-sources])\n", entry)
+			continue
+		}
+		scope := filterScopeConfig{cidr: strings.TrimSpace(parts[0]), blockSources: parseSourceList(parts[1])}
+		if len(parts) == 3 {
+			scope.allowSources = parseSourceList(parts[2])
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
 
 // Predefined static DNS records for test
 var staticRecords = map[string]map[uint16][]string{
@@ -43,9 +308,9 @@ var staticRecords = map[string]map[uint16][]string{
 		dns.TypeCNAME: {"example.com."},
 	},
 	"example.org.": {
-		dns.TypeA:    {"192.0.2.0"},
-		dns.TypeTXT:  {"\"Another example domain\""},
-		dns.TypeSRV:  {"10 0 80 http.example.org."},
+		dns.TypeA:   {"192.0.2.0"},
+		dns.TypeTXT: {"\"Another example domain\""},
+		dns.TypeSRV: {"10 0 80 http.example.org."},
 	},
 	"service._tcp.example.com.": {
 		dns.TypeSRV: {"10 0 80 server1.example.com."},
@@ -60,25 +325,92 @@ var staticRecords = map[string]map[uint16][]string{
 
 // DNSServerHandler implements the dns.Handler interface
 type DNSServerHandler struct {
-	upstreamDNSServer string
+	upstreams *upstream.Group
+	bootstrap upstream.Bootstrap
+	cache     cache.Cache
+	filters   *filter.Manager
+	queryLog  *querylog.Recorder
+	limiter   *ratelimit.Limiter
+	// maxCNAMEDepth caps how many CNAME hops resolve will follow.
+	maxCNAMEDepth int
+
+	enableECS             bool
+	enableRateLimit       bool
+	rateLimitTCOnExceeded bool
+	enableRefuseAny       bool
+
 	// Mutex to protect cache operations if not using Redis for everything
 	// For this example, Redis handles concurrency
 	mu sync.RWMutex
 }
 
-// NewDNSServerHandler creates a new DNSServerHandler
-func NewDNSServerHandler(upstream string) *DNSServerHandler {
+// NewDNSServerHandler creates a new DNSServerHandler that forwards queries
+// to cfg.upstreams, trying them according to cfg.strategy, caches responses
+// in c, blocks queries matching filters, and records each query/response to
+// queryLog (which may be nil to disable logging). Each upstream URL uses
+// the udp://, tcp://, tls://, https://, or quic:// scheme; hostnames in
+// tls/https/quic URLs are resolved once via bootstrap. cfg.maxCNAMEDepth
+// caps CNAME chain following; a value <= 0 falls back to
+// defaultMaxCNAMEChainDepth. limiter may be nil, in which case rate
+// limiting has no effect regardless of cfg.enableRateLimit.
+func NewDNSServerHandler(cfg config, bootstrap upstream.Bootstrap, c cache.Cache, filters *filter.Manager, queryLog *querylog.Recorder, limiter *ratelimit.Limiter) (*DNSServerHandler, error) {
+	ups := make([]upstream.Upstream, 0, len(cfg.upstreams))
+	for _, rawURL := range cfg.upstreams {
+		u, err := upstream.New(rawURL, bootstrap)
+		if err != nil {
+			return nil, fmt.Errorf("configuring upstream %q: %w", rawURL, err)
+		}
+		ups = append(ups, u)
+	}
+
+	group, err := upstream.NewGroup(ups, cfg.strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCNAMEDepth := cfg.maxCNAMEDepth
+	if maxCNAMEDepth <= 0 {
+		maxCNAMEDepth = defaultMaxCNAMEChainDepth
+	}
+
 	return &DNSServerHandler{
-		upstreamDNSServer: upstream,
+		upstreams:             group,
+		bootstrap:             bootstrap,
+		cache:                 c,
+		filters:               filters,
+		queryLog:              queryLog,
+		limiter:               limiter,
+		maxCNAMEDepth:         maxCNAMEDepth,
+		enableECS:             cfg.enableECS,
+		enableRateLimit:       cfg.enableRateLimit,
+		rateLimitTCOnExceeded: cfg.rateLimitTCOnExceeded,
+		enableRefuseAny:       cfg.enableRefuseAny,
+	}, nil
+}
+
+// SetUpstreams hot-swaps the resolver's upstream servers, re-running the
+// startup health check against the new set before switching over. The
+// handler keeps using its current upstreams if none of the new ones pass.
+func (h *DNSServerHandler) SetUpstreams(upstreamURLs []string) error {
+	ups := make([]upstream.Upstream, 0, len(upstreamURLs))
+	for _, rawURL := range upstreamURLs {
+		u, err := upstream.New(rawURL, h.bootstrap)
+		if err != nil {
+			return fmt.Errorf("configuring upstream %q: %w", rawURL, err)
+		}
+		ups = append(ups, u)
 	}
+	return h.upstreams.Reconfigure(ups)
 }
 
 // ServeDNS handles incoming DNS requests
 func (h *DNSServerHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	client := clientIP(w.RemoteAddr())
+
 	ctx := context.Background()
 	m := new(dns.Msg)
 	m.SetReply(r)
-	m.Authoritative = false // We are acting as a recursive resolver/caching server
 
 	if len(r.Question) == 0 {
 		m.SetRcode(r, dns.RcodeServerFailure)
@@ -90,110 +422,388 @@ func (h *DNSServerHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	qName := strings.ToLower(q.Name)
 	qType := q.Qtype
 
-	log.Printf("Received query for %s (Type %s) from %s\n", qName, dns.Type(qType).String(), w.RemoteAddr().String())
+	rc := requestContext{client: client, udpSize: dns.MinMsgSize}
+	if opt := r.IsEdns0(); opt != nil {
+		rc.edns = true
+		rc.do = opt.Do()
+		if sz := opt.UDPSize(); sz > rc.udpSize {
+			rc.udpSize = sz
+		}
+	}
 
+	var meta queryMeta
+
+	switch {
+	case h.enableRateLimit && h.limiter != nil && !h.limiter.Allow(client):
+		log.Printf("Rate limit exceeded for %s\n", client)
+		m = h.rateLimitResponse(r)
+		meta.source = querylog.SourceRateLimited
+	case h.enableRefuseAny && qType == dns.TypeANY:
+		log.Printf("Refusing ANY query for %s from %s\n", qName, client)
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		meta.source = querylog.SourceRefusedAny
+	default:
+		log.Printf("Received query for %s (Type %s) from %s\n", qName, dns.Type(qType).String(), w.RemoteAddr().String())
+
+		var answers, ns []dns.RR
+		var rcode int
+		answers, ns, rcode, meta = h.resolve(ctx, qName, qType, rc)
+		m.SetRcode(r, rcode)
+		m.Authoritative = rcode == dns.RcodeSuccess && meta.source == querylog.SourceStatic
+		// A CNAME chain that terminates in a non-success rcode (e.g. the
+		// final hop is upstream NXDOMAIN) still carries the already-resolved
+		// CNAME RRs, which a client needs to see the alias it was
+		// redirected through.
+		if len(answers) > 0 {
+			m.Answer = answers
+		}
+		m.Ns = ns
+	}
+
+	if rc.edns {
+		m.SetEdns0(rc.udpSize, rc.do)
+	}
+	if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+		truncateToSize(m, int(rc.udpSize))
+	}
+
+	w.WriteMsg(m)
+
+	if h.queryLog != nil {
+		go h.queryLog.Record(querylog.Entry{
+			Timestamp: start,
+			ClientIP:  rc.client.String(),
+			QName:     qName,
+			QType:     dns.Type(qType).String(),
+			Rcode:     dns.RcodeToString[m.Rcode],
+			Answer:    rrStrings(m.Answer),
+			ElapsedMs: float64(time.Since(start)) / float64(time.Millisecond),
+			Upstream:  meta.upstream,
+			Source:    meta.source,
+			Rule:      meta.rule,
+		})
+	}
+}
+
+// rrStrings renders each RR in rrs in zone-file presentation format, for
+// inclusion in a query log entry.
+func rrStrings(rrs []dns.RR) []string {
+	if len(rrs) == 0 {
+		return nil
+	}
+	out := make([]string, len(rrs))
+	for i, rr := range rrs {
+		out[i] = rr.String()
+	}
+	return out
+}
+
+// truncateToSize drops trailing Ns and then Answer records from m until its
+// wire-format length fits within maxSize, setting TC=1 so the client
+// retries over TCP. m's OPT record (in Extra) is left untouched.
+func truncateToSize(m *dns.Msg, maxSize int) {
+	if m.Len() <= maxSize {
+		return
+	}
+
+	m.Truncated = true
+	for len(m.Ns) > 0 && m.Len() > maxSize {
+		m.Ns = m.Ns[:len(m.Ns)-1]
+	}
+	for len(m.Answer) > 0 && m.Len() > maxSize {
+		m.Answer = m.Answer[:len(m.Answer)-1]
+	}
+}
+
+// clientIP extracts the client's IP address from a dns.ResponseWriter's
+// remote address, returning nil if it can't be parsed.
+func clientIP(addr net.Addr) net.IP {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// queryMeta carries query-log metadata alongside a resolve chain, set by
+// whichever lookupOne hop produces the final answer.
+type queryMeta struct {
+	source   string
+	upstream string
+	rule     string
+}
+
+// requestContext carries the per-query values resolve/lookupOne need
+// beyond the name and type being looked up: the client's address (for
+// filter scoping and ECS) and its negotiated EDNS0 parameters.
+type requestContext struct {
+	client  net.IP
+	udpSize uint16
+	do      bool
+	edns    bool // whether the client's query carried an OPT RR
+}
+
+// resolve looks up name/qType, checking static records, then the filter,
+// then the cache, then the upstream resolvers, and follows any resulting
+// CNAME chain (re-entering the same lookup for each hop) up to
+// h.maxCNAMEDepth hops. Both the initial query from ServeDNS and each
+// CNAME follow-up share this one code path via lookupOne. rc scopes which
+// filtering policy applies and what EDNS0 parameters to use upstream.
+func (h *DNSServerHandler) resolve(ctx context.Context, name string, qType uint16, rc requestContext) (answers, ns []dns.RR, rcode int, meta queryMeta) {
+	var m queryMeta
+	answers, ns, rcode = h.resolveChain(ctx, name, qType, rc, make(map[string]bool), 0, &m)
+	return answers, ns, rcode, m
+}
+
+func (h *DNSServerHandler) resolveChain(ctx context.Context, name string, qType uint16, rc requestContext, visited map[string]bool, depth int, meta *queryMeta) (answers, ns []dns.RR, rcode int) {
+	if depth > h.maxCNAMEDepth {
+		log.Printf("CNAME chain for %s exceeded max depth %d\n", name, h.maxCNAMEDepth)
+		return nil, nil, dns.RcodeServerFailure
+	}
+	if visited[name] {
+		log.Printf("CNAME loop detected at %s\n", name)
+		return nil, nil, dns.RcodeServerFailure
+	}
+	visited[name] = true
+
+	hopAnswers, hopNS, hopRcode := h.lookupOne(ctx, name, qType, rc, meta)
+	if qType == dns.TypeCNAME || hopRcode != dns.RcodeSuccess {
+		return hopAnswers, hopNS, hopRcode
+	}
+
+	for _, ans := range hopAnswers {
+		cname, ok := ans.(*dns.CNAME)
+		if !ok || !strings.EqualFold(cname.Hdr.Name, name) {
+			continue
+		}
+		log.Printf("Following CNAME %s -> %s (Type %s)\n", name, cname.Target, dns.Type(qType).String())
+
+		// A single upstream answer often already contains the whole chain
+		// (every intermediate CNAME plus the terminal record), which is
+		// the normal behavior of a real recursive upstream. Walk what we
+		// already have before issuing another upstream query, so a
+		// fully-resolved chain isn't re-resolved and duplicated.
+		next, complete := followChain(hopAnswers, strings.ToLower(cname.Target), qType)
+		if complete {
+			return hopAnswers, hopNS, hopRcode
+		}
+
+		restAnswers, restNS, restRcode := h.resolveChain(ctx, next, qType, rc, visited, depth+1, meta)
+		return append(hopAnswers, restAnswers...), restNS, restRcode
+	}
+
+	return hopAnswers, hopNS, hopRcode
+}
+
+// followChain walks the CNAME links already present in answers, starting
+// at name, until it either reaches a record of qType (the chain is
+// already fully resolved, so complete is true) or runs out of further
+// CNAMEs to follow (next is the name still needing an upstream lookup).
+func followChain(answers []dns.RR, name string, qType uint16) (next string, complete bool) {
+	seen := make(map[string]bool)
+	for !seen[name] {
+		seen[name] = true
+		var nextCNAME string
+		for _, ans := range answers {
+			if !strings.EqualFold(ans.Header().Name, name) {
+				continue
+			}
+			if ans.Header().Rrtype == qType {
+				return "", true
+			}
+			if cname, ok := ans.(*dns.CNAME); ok {
+				nextCNAME = strings.ToLower(cname.Target)
+			}
+		}
+		if nextCNAME == "" {
+			return name, false
+		}
+		name = nextCNAME
+	}
+	// A CNAME loop within the same upstream answer; report it as
+	// unresolved rather than fully resolved so the caller re-queries and
+	// the normal loop detection in resolveChain catches it.
+	return name, false
+}
+
+// lookupOne resolves a single name/qType pair against static records, then
+// the filter, then the cache, then upstream, without following any CNAME
+// it finds. It records which stage produced the answer (and, for an
+// upstream answer, which upstream) into meta for the query log.
+func (h *DNSServerHandler) lookupOne(ctx context.Context, name string, qType uint16, rc requestContext, meta *queryMeta) (answers, ns []dns.RR, rcode int) {
 	// 1. Check Static Records First
-	if staticData, ok := staticRecords[qName]; ok {
+	if staticData, ok := staticRecords[name]; ok {
 		if records, found := staticData[qType]; found {
-			log.Printf("Found static record for %s (Type %s)\n", qName, dns.Type(qType).String())
-			h.addRecordsToMsg(m, q, records, dns.ClassINET, 60, true) // Static records are authoritative for us
-			w.WriteMsg(m)
-			return
+			log.Printf("Found static record for %s (Type %s)\n", name, dns.Type(qType).String())
+			meta.source = querylog.SourceStatic
+			return h.parseRecords(records, 60), nil, dns.RcodeSuccess
+		}
+		if qType != dns.TypeCNAME {
+			if records, found := staticData[dns.TypeCNAME]; found {
+				log.Printf("Found static CNAME for %s\n", name)
+				meta.source = querylog.SourceStatic
+				return h.parseRecords(records, 60), nil, dns.RcodeSuccess
+			}
 		}
 	}
 
-	// 2. Check Redis Cache
-	cacheKey := fmt.Sprintf("%s:%d", qName, qType)
-	cachedValue, err := redisClient.Get(ctx, cacheKey).Result()
-	if err == nil {
-		log.Printf("Found cached record for %s (Type %s)\n", qName, dns.Type(qType).String())
-		records := strings.Split(cachedValue, "|")
-		h.addRecordsToMsg(m, q, records, dns.ClassINET, 300, false) // Cached records might have shorter TTLs or be non-authoritative
-		w.WriteMsg(m)
-		return
-	} else if err != redis.Nil {
-		log.Printf("Error checking Redis cache: %v\n", err)
+	// 2. Check the filter
+	if f := h.filters.For(rc.client); f != nil {
+		if decision := f.Check(name); decision.Blocked {
+			log.Printf("Blocked %s (Type %s) for %s by rule %s\n", name, dns.Type(qType).String(), rc.client, decision.Rule)
+			meta.source = querylog.SourceBlocked
+			meta.rule = decision.Rule
+			return h.blockedResponse(name, qType, decision)
+		}
+	}
+
+	// ECS (if enabled) trims the client's address to a subnet and forwards
+	// it upstream; responses then need to be cached per subnet, since two
+	// clients in different networks can get different, geo-specific
+	// answers for the same name.
+	scope := ""
+	if h.enableECS {
+		if subnet := ecs.Subnet(rc.client); subnet != nil {
+			scope = fmt.Sprintf("%s/%d", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+	// A DO=true query can get RRSIGs back from upstream; scoping the cache
+	// key by the DO bit keeps those from being replayed to a later DO=false
+	// client that never asked for DNSSEC records.
+	if rc.do {
+		scope += ";dnssec"
 	}
 
-	// 3. Query Upstream DNS Server
-	log.Printf("Querying upstream server %s for %s (Type %s)\n", h.upstreamDNSServer, qName, dns.Type(qType).String())
-	upstreamClient := new(dns.Client)
-	upstreamClient.DialTimeout = 5 * time.Second
-	upstreamClient.ReadTimeout = 5 * time.Second
-	upstreamClient.WriteTimeout = 5 * time.Second
+	// 3. Check Cache
+	cacheKey := cache.KeyWithScope(name, qType, dns.ClassINET, scope)
+	if cached, err := h.cache.Get(cacheKey); err == nil {
+		log.Printf("Found cached record for %s (Type %s)\n", name, dns.Type(qType).String())
+		meta.source = querylog.SourceCache
+		return cached.Answer, cached.Ns, cached.Rcode
+	} else if err != cache.ErrMiss {
+		log.Printf("Error checking cache: %v\n", err)
+	}
+
+	// 4. Query Upstream DNS Server
+	log.Printf("Querying upstream for %s (Type %s)\n", name, dns.Type(qType).String())
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qType)
+
+	if rc.edns || h.enableECS {
+		query.SetEdns0(rc.udpSize, rc.do)
+		if h.enableECS {
+			if subnet := ecs.Subnet(rc.client); subnet != nil {
+				opt := query.IsEdns0()
+				opt.Option = append(opt.Option, subnet)
+			}
+		}
+	}
 
-	upstreamMsg, _, err := upstreamClient.Exchange(r, h.upstreamDNSServer)
+	upstreamMsg, usedUpstream, err := h.upstreams.Exchange(query)
+	meta.source = querylog.SourceUpstream
+	if usedUpstream != nil {
+		meta.upstream = usedUpstream.String()
+	}
 	if err != nil {
 		log.Printf("Error querying upstream DNS server: %v\n", err)
-		m.SetRcode(r, dns.RcodeServerFailure)
-		w.WriteMsg(m)
-		return
+		return nil, nil, dns.RcodeServerFailure
+	}
+
+	// Cache positive and negative (NXDOMAIN/NODATA) responses alike so a
+	// repeated miss doesn't keep hitting upstream. Anything else (e.g. a
+	// transient SERVFAIL) is left uncached so the next query retries
+	// upstream instead of replaying the failure for a cache.Put-derived TTL.
+	if upstreamMsg.Rcode == dns.RcodeSuccess || upstreamMsg.Rcode == dns.RcodeNameError {
+		h.cache.Put(cacheKey, upstreamMsg)
 	}
 
 	if upstreamMsg.Rcode != dns.RcodeSuccess {
-		log.Printf("Upstream server returned RCODE %d for %s (Type %s)\n", upstreamMsg.Rcode, qName, dns.Type(qType).String())
-		m.SetRcode(r, upstreamMsg.Rcode)
-		w.WriteMsg(m)
-		return
+		log.Printf("Upstream server returned RCODE %d for %s (Type %s)\n", upstreamMsg.Rcode, name, dns.Type(qType).String())
+		return nil, nil, upstreamMsg.Rcode
 	}
 
-	// Process upstream response
 	if len(upstreamMsg.Answer) > 0 {
-		m.Answer = upstreamMsg.Answer
-		// Cache the upstream response
-		var records []string
-		for _, ans := range upstreamMsg.Answer {
-			records = append(records, ans.String())
-		}
-		serializedRecords := strings.Join(records, "|")
-		err := redisClient.Set(ctx, cacheKey, serializedRecords, redisCacheTTL).Err()
-		if err != nil {
-			log.Printf("Error caching response in Redis: %v\n", err)
-		} else {
-			log.Printf("Cached upstream response for %s (Type %s)\n", qName, dns.Type(qType).String())
-		}
-	} else if len(upstreamMsg.Ns) > 0 {
-		// If no answer, but NS records are present, include them in the response
-		// This happens for delegations
-		m.Ns = upstreamMsg.Ns
-	} else {
-		// No records found, set NXDOMAIN if upstream indicates so, otherwise NoError with no answers
-		if upstreamMsg.Rcode == dns.RcodeNameError {
-			m.SetRcode(r, dns.RcodeNameError)
-		}
+		return upstreamMsg.Answer, nil, dns.RcodeSuccess
 	}
 
-	w.WriteMsg(m)
+	if len(upstreamMsg.Ns) > 0 {
+		// No answer, but NS records are present; this happens for delegations.
+		return nil, upstreamMsg.Ns, dns.RcodeSuccess
+	}
+
+	return nil, nil, dns.RcodeSuccess
 }
 
-func (h *DNSServerHandler) addRecordsToMsg(m *dns.Msg, q dns.Question, records []string, class uint16, ttl uint32, authoritative bool) {
-	m.Authoritative = authoritative
+// parseRecords turns the static string-encoded records into RRs with
+// their TTL overridden, skipping any that fail to parse.
+func (h *DNSServerHandler) parseRecords(records []string, ttl uint32) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
 	for _, recStr := range records {
 		rr, err := dns.NewRR(recStr)
 		if err != nil {
 			log.Printf("Error parsing record string '%s': %v\n", recStr, err)
 			continue
 		}
-	if rr.Header().Name == q.Name || q.Qtype == dns.TypeCNAME || rr.Header().Rrtype == dns.TypeCNAME {
-			rr.Header().Ttl = ttl // Override TTL for static/cached records if desired
-			m.Answer = append(m.Answer, rr)
-		} else {
-			log.Printf("Skipping record '%s' as it does not match query %s (Type %s)\n", recStr, q.Name, dns.Type(q.Qtype).String())
-		}
+		rr.Header().Ttl = ttl
+		rrs = append(rrs, rr)
 	}
-if q.Qtype != dns.TypeCNAME {
-		// Check if the answer contains a CNAME for the queried name
-		for _, ans := range m.Answer {
-			if cname, ok := ans.(*dns.CNAME); ok && cname.Hdr.Name == q.Name {
-				log.Printf("CNAME for %s found: %s. A more advanced resolver would now follow this.", q.Name, cname.Target)
-			break
-			}
-		}
+	return rrs
+}
+
+// blockedResponse builds the answer/ns/rcode to use for a query the filter
+// decided to block, per its Mode.
+func (h *DNSServerHandler) blockedResponse(name string, qType uint16, decision filter.Decision) (answers, ns []dns.RR, rcode int) {
+	switch decision.Mode {
+	case filter.ModeRefused:
+		return nil, nil, dns.RcodeRefused
+	case filter.ModeNull:
+		return h.nullRecords(name, qType), nil, dns.RcodeSuccess
+	default:
+		return nil, nil, dns.RcodeNameError
+	}
+}
+
+// nullRecords synthesizes a 0.0.0.0 (A) or :: (AAAA) answer for a blocked
+// name; other query types get no answer at all.
+func (h *DNSServerHandler) nullRecords(name string, qType uint16) []dns.RR {
+	var rrStr string
+	switch qType {
+	case dns.TypeA:
+		rrStr = fmt.Sprintf("%s 0 IN A 0.0.0.0", dns.Fqdn(name))
+	case dns.TypeAAAA:
+		rrStr = fmt.Sprintf("%s 0 IN AAAA ::", dns.Fqdn(name))
+	default:
+		return nil
+	}
+
+	rr, err := dns.NewRR(rrStr)
+	if err != nil {
+		log.Printf("Error building null record for %s: %v\n", name, err)
+		return nil
 	}
+	return []dns.RR{rr}
 }
 
-// setupRedis initializes the Redis client
-func setupRedis() {
+// rateLimitResponse builds the reply for a query that exceeded its
+// client's rate limit: REFUSED, or SERVFAIL+TC if h.rateLimitTCOnExceeded is
+// set.
+func (h *DNSServerHandler) rateLimitResponse(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if h.rateLimitTCOnExceeded {
+		m.SetRcode(r, dns.RcodeServerFailure)
+		m.Truncated = true
+	} else {
+		m.SetRcode(r, dns.RcodeRefused)
+	}
+	return m
+}
+
+// newCache connects to Redis and wraps it as a Cache. If Redis is
+// unreachable, it falls back to an in-memory LRU cache so the server can
+// still start, at the cost of not sharing its cache across restarts. bounds
+// clamps how long entries may live in either case.
+func newCache(bounds cache.TTLBounds) cache.Cache {
 	redisClient = redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379", // Replace with your Redis server address
 		Password: "",               // No password by default
@@ -203,22 +813,57 @@ func setupRedis() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := redisClient.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Could not connect to Redis: %v. Please ensure Redis is running.", err)
+	if _, err := redisClient.Ping(ctx).Result(); err != nil {
+		log.Printf("Could not connect to Redis: %v. Falling back to in-memory cache.\n", err)
+		return cache.NewLRUCache(lruCacheFallbackCapacity, bounds)
 	}
+
 	log.Println("Successfully connected to Redis.")
+	return cache.NewRedisCache(redisClient, bounds)
 }
 
 func main() {
 	log.Println("Starting comprehensive DNS server...")
 
-	setupRedis()
+	cfg := parseFlags()
+
+	c := newCache(cfg.cacheTTLBounds)
 
-	port := 53
-	addr := ":" + strconv.Itoa(port)
+	blockFilter := filter.NewFilter(cfg.blockSources, cfg.allowSources, cfg.filterMode, cfg.filterRefreshInterval)
+	filters := filter.NewManager(blockFilter)
+	for _, s := range cfg.filterScopes {
+		scopeFilter := filter.NewFilter(s.blockSources, s.allowSources, cfg.filterMode, cfg.filterRefreshInterval)
+		if err := filters.AddScope(s.cidr, scopeFilter); err != nil {
+			log.Fatalf("Failed to add filter scope %q: %v", s.cidr, err)
+		}
+	}
 
-	handler := NewDNSServerHandler(defaultUpstreamDNSServer)
+	recorder, err := querylog.NewRecorder(cfg.queryLogDir, queryLogRingCapacity, cfg.queryLogRetention)
+	if err != nil {
+		log.Fatalf("Failed to set up query log: %v", err)
+	}
+
+	limiter, err := ratelimit.NewLimiter(cfg.rateLimitQPS, cfg.rateLimitBurst, cfg.rateLimitAllowCIDRs)
+	if err != nil {
+		log.Fatalf("Failed to set up rate limiter: %v", err)
+	}
+	limiter.StartGC(rateLimitGCInterval, rateLimitIdleTTL)
+
+	addr := ":" + strconv.Itoa(cfg.port)
+
+	bootstrap := upstream.NewBootstrap(cfg.bootstrapDNS)
+	handler, err := NewDNSServerHandler(cfg, bootstrap, c, filters, recorder, limiter)
+	if err != nil {
+		log.Fatalf("Failed to set up upstreams: %v", err)
+	}
+
+	adminServer := admin.NewServer(recorder, c, handler.SetUpstreams, cfg.adminToken)
+	go func() {
+		log.Printf("Listening on admin HTTP %s\n", cfg.adminAddr)
+		if err := adminServer.ListenAndServe(cfg.adminAddr); err != nil {
+			log.Printf("Admin server stopped: %v\n", err)
+		}
+	}()
 
 	// Start UDP server
 	udpServer := &dns.Server{Addr: addr, Net: "udp", Handler: handler}
@@ -229,7 +874,7 @@ func main() {
 		}
 	}()
 
-	// Start TCP server 
+	// Start TCP server
 	tcpServer := &dns.Server{Addr: addr, Net: "tcp", Handler: handler}
 	log.Printf("Listening on TCP %s\n", addr)
 	if err := tcpServer.ListenAndServe(); err != nil {
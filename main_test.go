@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/GauthamaSid/DNS_GOlang/cache"
+	"github.com/GauthamaSid/DNS_GOlang/filter"
+	"github.com/GauthamaSid/DNS_GOlang/querylog"
+	"github.com/GauthamaSid/DNS_GOlang/ratelimit"
+	"github.com/GauthamaSid/DNS_GOlang/upstream"
+)
+
+// fakeResponseWriter is a minimal dns.ResponseWriter that just captures the
+// message it was asked to write, so tests can drive ServeDNS end-to-end and
+// inspect the wire-level reply.
+type fakeResponseWriter struct {
+	remoteAddr net.Addr
+	written    *dns.Msg
+}
+
+func (w *fakeResponseWriter) LocalAddr() net.Addr  { return &net.UDPAddr{IP: net.ParseIP("127.0.0.1")} }
+func (w *fakeResponseWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+func (w *fakeResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.written = m
+	return nil
+}
+func (w *fakeResponseWriter) Write([]byte) (int, error) { return 0, nil }
+func (w *fakeResponseWriter) Close() error              { return nil }
+func (w *fakeResponseWriter) TsigStatus() error         { return nil }
+func (w *fakeResponseWriter) TsigTimersOnly(bool)       {}
+func (w *fakeResponseWriter) Hijack()                   {}
+
+// fakeUpstream answers a fixed set of canned replies keyed by qname/qtype,
+// and anything else (including the Group's startup health check) with an
+// empty NOERROR.
+type fakeUpstream struct {
+	replies map[string]*dns.Msg
+}
+
+func (f *fakeUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	if len(m.Question) == 1 {
+		key := fmt.Sprintf("%s:%d", m.Question[0].Name, m.Question[0].Qtype)
+		if canned, ok := f.replies[key]; ok {
+			reply.Answer = canned.Answer
+			reply.Ns = canned.Ns
+			reply.Rcode = canned.Rcode
+		}
+	}
+	return reply, nil
+}
+
+func (f *fakeUpstream) String() string { return "fake://upstream" }
+
+func newTestHandler(t *testing.T, replies map[string]*dns.Msg) *DNSServerHandler {
+	t.Helper()
+
+	group, err := upstream.NewGroup([]upstream.Upstream{&fakeUpstream{replies: replies}}, upstream.RoundRobin)
+	if err != nil {
+		t.Fatalf("upstream.NewGroup: %v", err)
+	}
+
+	return &DNSServerHandler{
+		upstreams:     group,
+		cache:         cache.NewLRUCache(10, cache.DefaultTTLBounds),
+		filters:       filter.NewManager(nil),
+		maxCNAMEDepth: defaultMaxCNAMEChainDepth,
+	}
+}
+
+// recordingUpstream captures the last query it was asked to exchange, so
+// tests can inspect what resolve/ServeDNS attached to it (e.g. an EDNS0 OPT
+// with a client-subnet option), and always answers with a fixed A record.
+type recordingUpstream struct {
+	lastQuery *dns.Msg
+}
+
+func (u *recordingUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	u.lastQuery = m
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	if len(m.Question) == 1 {
+		rr, _ := dns.NewRR(m.Question[0].Name + " 300 IN A 192.0.2.10")
+		reply.Answer = []dns.RR{rr}
+	}
+	return reply, nil
+}
+
+func (u *recordingUpstream) String() string { return "fake://recording" }
+
+// dnssecUpstream answers with an A record, adding an RRSIG only when the
+// query carries DO=true, mimicking a real DNSSEC-aware resolver.
+type dnssecUpstream struct{}
+
+func (u *dnssecUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	rr, _ := dns.NewRR(m.Question[0].Name + " 300 IN A 192.0.2.20")
+	reply.Answer = []dns.RR{rr}
+	if opt := m.IsEdns0(); opt != nil && opt.Do() {
+		rrsig, _ := dns.NewRR(m.Question[0].Name + " 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 12345 example.net. AAAA==")
+		reply.Answer = append(reply.Answer, rrsig)
+	}
+	return reply, nil
+}
+
+func (u *dnssecUpstream) String() string { return "fake://dnssec" }
+
+func TestCacheKeyScopedByDOBit(t *testing.T) {
+	group, err := upstream.NewGroup([]upstream.Upstream{&dnssecUpstream{}}, upstream.RoundRobin)
+	if err != nil {
+		t.Fatalf("upstream.NewGroup: %v", err)
+	}
+
+	h := &DNSServerHandler{
+		upstreams:     group,
+		cache:         cache.NewLRUCache(10, cache.DefaultTTLBounds),
+		filters:       filter.NewManager(nil),
+		maxCNAMEDepth: defaultMaxCNAMEChainDepth,
+	}
+
+	doRC := requestContext{udpSize: dns.MinMsgSize, edns: true, do: true}
+	answers, _, rcode, _ := h.resolve(context.Background(), "signed.example.net.", dns.TypeA, doRC)
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %d, want RcodeSuccess", rcode)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("DO=true answer has %d RRs, want 2 (A + RRSIG)", len(answers))
+	}
+
+	noDORC := requestContext{udpSize: dns.MinMsgSize, edns: true, do: false}
+	answers, _, rcode, _ = h.resolve(context.Background(), "signed.example.net.", dns.TypeA, noDORC)
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %d, want RcodeSuccess", rcode)
+	}
+	if len(answers) != 1 {
+		t.Fatalf("DO=false answer has %d RRs, want 1 (cached DO=true entry must not be reused): %v", len(answers), answers)
+	}
+}
+
+func TestResolveAttachesECSOptionWhenEnabled(t *testing.T) {
+	up := &recordingUpstream{}
+	group, err := upstream.NewGroup([]upstream.Upstream{up}, upstream.RoundRobin)
+	if err != nil {
+		t.Fatalf("upstream.NewGroup: %v", err)
+	}
+
+	h := &DNSServerHandler{
+		upstreams:     group,
+		cache:         cache.NewLRUCache(10, cache.DefaultTTLBounds),
+		filters:       filter.NewManager(nil),
+		maxCNAMEDepth: defaultMaxCNAMEChainDepth,
+		enableECS:     true,
+	}
+
+	rc := requestContext{client: net.ParseIP("203.0.113.42"), udpSize: dns.MinMsgSize}
+	_, _, rcode, _ := h.resolve(context.Background(), "not-a-static-record.example.net.", dns.TypeA, rc)
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %d, want RcodeSuccess", rcode)
+	}
+
+	opt := up.lastQuery.IsEdns0()
+	if opt == nil {
+		t.Fatal("outgoing query has no OPT record; SetEdns0 should have attached one")
+	}
+
+	var subnet *dns.EDNS0_SUBNET
+	for _, o := range opt.Option {
+		if s, ok := o.(*dns.EDNS0_SUBNET); ok {
+			subnet = s
+		}
+	}
+	if subnet == nil {
+		t.Fatal("outgoing OPT record has no EDNS0_SUBNET option")
+	}
+	if got, want := subnet.Address.String(), "203.0.113.0"; got != want {
+		t.Errorf("subnet address = %s, want %s", got, want)
+	}
+}
+
+func TestTruncateToSizeSetsTCAndDropsRecordsUntilItFits(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("big.example.com.", dns.TypeA)
+	for i := 0; i < 200; i++ {
+		rr, _ := dns.NewRR(fmt.Sprintf("big.example.com. 300 IN A 192.0.2.%d", i%256))
+		m.Answer = append(m.Answer, rr)
+	}
+
+	truncateToSize(m, dns.MinMsgSize)
+
+	if !m.Truncated {
+		t.Fatal("expected Truncated to be set once the message exceeds maxSize")
+	}
+	if m.Len() > dns.MinMsgSize {
+		t.Fatalf("Len() = %d after truncation, want <= %d", m.Len(), dns.MinMsgSize)
+	}
+}
+
+func TestTruncateToSizeLeavesSmallMessageUntouched(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("small.example.com.", dns.TypeA)
+	rr, _ := dns.NewRR("small.example.com. 300 IN A 192.0.2.1")
+	m.Answer = []dns.RR{rr}
+
+	truncateToSize(m, dns.MinMsgSize)
+
+	if m.Truncated {
+		t.Fatal("a message well under maxSize should not be marked Truncated")
+	}
+	if len(m.Answer) != 1 {
+		t.Fatalf("got %d answers, want 1 (nothing should be dropped)", len(m.Answer))
+	}
+}
+
+func TestResolveChainFullyResolvedUpstreamAnswer(t *testing.T) {
+	// The upstream returns the entire chain plus the terminal A record in
+	// one answer, as a real recursive resolver does for an externally
+	// chained name.
+	canned := new(dns.Msg)
+	canned.Rcode = dns.RcodeSuccess
+	canned.Answer = []dns.RR{
+		mustRR(t, "chain.example.net. 300 IN CNAME mid.example.net."),
+		mustRR(t, "mid.example.net. 300 IN CNAME final.example.net."),
+		mustRR(t, "final.example.net. 300 IN A 192.0.2.5"),
+	}
+
+	h := newTestHandler(t, map[string]*dns.Msg{
+		"chain.example.net.:1": canned,
+	})
+
+	answers, _, rcode, _ := h.resolve(context.Background(), "chain.example.net.", dns.TypeA, requestContext{udpSize: dns.MinMsgSize})
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %d, want RcodeSuccess", rcode)
+	}
+	if len(answers) != 3 {
+		t.Fatalf("got %d answers, want 3 (no duplication): %v", len(answers), answers)
+	}
+}
+
+func TestResolveChainFollowsPartialChain(t *testing.T) {
+	// The upstream only resolves one hop at a time, so resolve must issue
+	// a second upstream query for the CNAME target.
+	first := new(dns.Msg)
+	first.Rcode = dns.RcodeSuccess
+	first.Answer = []dns.RR{mustRR(t, "chain.example.net. 300 IN CNAME final.example.net.")}
+
+	second := new(dns.Msg)
+	second.Rcode = dns.RcodeSuccess
+	second.Answer = []dns.RR{mustRR(t, "final.example.net. 300 IN A 192.0.2.5")}
+
+	h := newTestHandler(t, map[string]*dns.Msg{
+		"chain.example.net.:1": first,
+		"final.example.net.:1": second,
+	})
+
+	answers, _, rcode, _ := h.resolve(context.Background(), "chain.example.net.", dns.TypeA, requestContext{udpSize: dns.MinMsgSize})
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("rcode = %d, want RcodeSuccess", rcode)
+	}
+	if len(answers) != 2 {
+		t.Fatalf("got %d answers, want 2: %v", len(answers), answers)
+	}
+}
+
+func TestServeDNSKeepsResolvedCNAMEsWhenChainEndsInFailure(t *testing.T) {
+	// The CNAME target doesn't exist upstream, but the client still needs
+	// to see the alias it was redirected through.
+	first := new(dns.Msg)
+	first.Rcode = dns.RcodeSuccess
+	first.Answer = []dns.RR{mustRR(t, "chain.example.net. 300 IN CNAME missing.example.net.")}
+
+	second := new(dns.Msg)
+	second.Rcode = dns.RcodeNameError
+
+	h := newTestHandler(t, map[string]*dns.Msg{
+		"chain.example.net.:1":   first,
+		"missing.example.net.:1": second,
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion("chain.example.net.", dns.TypeA)
+	w := &fakeResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.4"), Port: 12345}}
+	h.ServeDNS(w, query)
+
+	if w.written.Rcode != dns.RcodeNameError {
+		t.Fatalf("Rcode = %d, want RcodeNameError", w.written.Rcode)
+	}
+	if len(w.written.Answer) != 1 {
+		t.Fatalf("got %d answers, want 1 (the resolved CNAME): %v", len(w.written.Answer), w.written.Answer)
+	}
+	if cname, ok := w.written.Answer[0].(*dns.CNAME); !ok || cname.Target != "missing.example.net." {
+		t.Fatalf("Answer[0] = %v, want CNAME to missing.example.net.", w.written.Answer[0])
+	}
+}
+
+func TestResolveChainDetectsCrossQueryLoop(t *testing.T) {
+	// Each upstream answer only resolves a single hop, so resolveChain must
+	// re-query upstream for the next name in the chain; the two targets
+	// point back at each other, forming a loop only detectable across
+	// separate queries (unlike TestFollowChainDetectsLoop, which only
+	// exercises the single-answer helper).
+	first := new(dns.Msg)
+	first.Rcode = dns.RcodeSuccess
+	first.Answer = []dns.RR{mustRR(t, "loop-a.example.net. 300 IN CNAME loop-b.example.net.")}
+
+	second := new(dns.Msg)
+	second.Rcode = dns.RcodeSuccess
+	second.Answer = []dns.RR{mustRR(t, "loop-b.example.net. 300 IN CNAME loop-a.example.net.")}
+
+	h := newTestHandler(t, map[string]*dns.Msg{
+		"loop-a.example.net.:1": first,
+		"loop-b.example.net.:1": second,
+	})
+
+	_, _, rcode, _ := h.resolve(context.Background(), "loop-a.example.net.", dns.TypeA, requestContext{udpSize: dns.MinMsgSize})
+	if rcode != dns.RcodeServerFailure {
+		t.Fatalf("rcode = %d, want RcodeServerFailure for a CNAME loop spanning separate upstream queries", rcode)
+	}
+}
+
+func TestResolveChainExceedsMaxDepth(t *testing.T) {
+	first := new(dns.Msg)
+	first.Rcode = dns.RcodeSuccess
+	first.Answer = []dns.RR{mustRR(t, "deep0.example.net. 300 IN CNAME deep1.example.net.")}
+
+	second := new(dns.Msg)
+	second.Rcode = dns.RcodeSuccess
+	second.Answer = []dns.RR{mustRR(t, "deep1.example.net. 300 IN CNAME deep2.example.net.")}
+
+	group, err := upstream.NewGroup([]upstream.Upstream{&fakeUpstream{replies: map[string]*dns.Msg{
+		"deep0.example.net.:1": first,
+		"deep1.example.net.:1": second,
+	}}}, upstream.RoundRobin)
+	if err != nil {
+		t.Fatalf("upstream.NewGroup: %v", err)
+	}
+
+	h := &DNSServerHandler{
+		upstreams:     group,
+		cache:         cache.NewLRUCache(10, cache.DefaultTTLBounds),
+		filters:       filter.NewManager(nil),
+		maxCNAMEDepth: 1,
+	}
+
+	_, _, rcode, _ := h.resolve(context.Background(), "deep0.example.net.", dns.TypeA, requestContext{udpSize: dns.MinMsgSize})
+	if rcode != dns.RcodeServerFailure {
+		t.Fatalf("rcode = %d, want RcodeServerFailure once the chain exceeds maxCNAMEDepth", rcode)
+	}
+}
+
+func TestFollowChainDetectsLoop(t *testing.T) {
+	answers := []dns.RR{
+		mustRR(t, "a.example.com. 300 IN CNAME b.example.com."),
+		mustRR(t, "b.example.com. 300 IN CNAME a.example.com."),
+	}
+
+	next, complete := followChain(answers, "a.example.com.", dns.TypeA)
+	if complete {
+		t.Fatal("a CNAME loop within the answer should not be reported as complete")
+	}
+	if next == "" {
+		t.Fatal("expected a non-empty next name to re-query upstream for")
+	}
+}
+
+func TestServeDNSRefusesPastRateLimitBudgetAndRefillsOverTime(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(1, 1, nil)
+	if err != nil {
+		t.Fatalf("ratelimit.NewLimiter: %v", err)
+	}
+
+	h := newTestHandler(t, nil)
+	h.enableRateLimit = true
+	h.limiter = limiter
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	client := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 12345}
+
+	w := &fakeResponseWriter{remoteAddr: client}
+	h.ServeDNS(w, query)
+	if w.written.Rcode != dns.RcodeSuccess {
+		t.Fatalf("first query rcode = %d, want RcodeSuccess (burst of 1 should allow it)", w.written.Rcode)
+	}
+
+	w = &fakeResponseWriter{remoteAddr: client}
+	h.ServeDNS(w, query)
+	if w.written.Rcode != dns.RcodeRefused {
+		t.Fatalf("second query (past budget) rcode = %d, want RcodeRefused", w.written.Rcode)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	w = &fakeResponseWriter{remoteAddr: client}
+	h.ServeDNS(w, query)
+	if w.written.Rcode != dns.RcodeSuccess {
+		t.Fatalf("query after refill rcode = %d, want RcodeSuccess", w.written.Rcode)
+	}
+}
+
+func TestServeDNSRefusesANYQueriesWhenEnabled(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.enableRefuseAny = true
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeANY)
+	w := &fakeResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 12345}}
+
+	h.ServeDNS(w, query)
+	if w.written.Rcode != dns.RcodeNotImplemented {
+		t.Fatalf("rcode = %d, want RcodeNotImplemented", w.written.Rcode)
+	}
+}
+
+func TestServeDNSNotAuthoritativeForUncoveredQType(t *testing.T) {
+	// sub.example.com. only has a static A record, so an AAAA query for it
+	// must fall through to upstream and must not be marked authoritative
+	// just because the name has some static entry.
+	canned := new(dns.Msg)
+	canned.Rcode = dns.RcodeSuccess
+	canned.Answer = []dns.RR{mustRR(t, "sub.example.com. 300 IN AAAA 2001:db8::1")}
+
+	h := newTestHandler(t, map[string]*dns.Msg{
+		"sub.example.com.:28": canned,
+	})
+
+	query := new(dns.Msg)
+	query.SetQuestion("sub.example.com.", dns.TypeAAAA)
+	w := &fakeResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.3"), Port: 12345}}
+	h.ServeDNS(w, query)
+
+	if w.written.Authoritative {
+		t.Fatal("Authoritative = true, want false for a qtype not covered by the static entry")
+	}
+}
+
+func TestServeDNSLogsRateLimitedQueries(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(1, 0, nil)
+	if err != nil {
+		t.Fatalf("ratelimit.NewLimiter: %v", err)
+	}
+
+	recorder, err := querylog.NewRecorder(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatalf("querylog.NewRecorder: %v", err)
+	}
+
+	h := newTestHandler(t, nil)
+	h.enableRateLimit = true
+	h.limiter = limiter
+	h.queryLog = recorder
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	w := &fakeResponseWriter{remoteAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.2"), Port: 12345}}
+	h.ServeDNS(w, query)
+	if w.written.Rcode != dns.RcodeRefused {
+		t.Fatalf("rcode = %d, want RcodeRefused", w.written.Rcode)
+	}
+
+	// Record is fired in a goroutine; poll briefly instead of sleeping a
+	// fixed, possibly-flaky amount.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if entries := recorder.GetRecent(querylog.Filter{}); len(entries) == 1 {
+			if entries[0].Source != querylog.SourceRateLimited {
+				t.Fatalf("Source = %q, want %q", entries[0].Source, querylog.SourceRateLimited)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("rate-limited query never reached the query log")
+}
+
+func TestParseFilterScopesSplitsCIDRAndSources(t *testing.T) {
+	got := parseFilterScopes("192.168.1.0/24:/etc/kids-block.txt;10.0.0.0/8:a.txt,b.txt")
+	if len(got) != 2 {
+		t.Fatalf("got %d scopes, want 2: %v", len(got), got)
+	}
+	if got[0].cidr != "192.168.1.0/24" || len(got[0].blockSources) != 1 || got[0].blockSources[0].Location != "/etc/kids-block.txt" {
+		t.Errorf("scope 0 = %+v, want cidr 192.168.1.0/24 with one block source", got[0])
+	}
+	if len(got[0].allowSources) != 0 {
+		t.Errorf("scope 0 = %+v, want no allow sources", got[0])
+	}
+	if got[1].cidr != "10.0.0.0/8" || len(got[1].blockSources) != 2 {
+		t.Errorf("scope 1 = %+v, want cidr 10.0.0.0/8 with two block sources", got[1])
+	}
+}
+
+func TestParseFilterScopesSkipsMalformedEntries(t *testing.T) {
+	got := parseFilterScopes("no-colon-here;192.168.1.0/24:a.txt")
+	if len(got) != 1 || got[0].cidr != "192.168.1.0/24" {
+		t.Fatalf("got %v, want only the well-formed entry", got)
+	}
+}
+
+func TestParseFilterScopesParsesAllowSources(t *testing.T) {
+	got := parseFilterScopes("192.168.1.0/24:block.txt:allow1.txt,allow2.txt")
+	if len(got) != 1 {
+		t.Fatalf("got %d scopes, want 1: %v", len(got), got)
+	}
+	if len(got[0].blockSources) != 1 || got[0].blockSources[0].Location != "block.txt" {
+		t.Errorf("blockSources = %+v, want one entry \"block.txt\"", got[0].blockSources)
+	}
+	if len(got[0].allowSources) != 2 || got[0].allowSources[0].Location != "allow1.txt" || got[0].allowSources[1].Location != "allow2.txt" {
+		t.Errorf("allowSources = %+v, want [allow1.txt allow2.txt]", got[0].allowSources)
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return rr
+}
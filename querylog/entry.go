@@ -0,0 +1,27 @@
+package querylog
+
+import "time"
+
+// Source values tag how a query's answer was produced.
+const (
+	SourceStatic      = "static"
+	SourceBlocked     = "blocked"
+	SourceCache       = "cache"
+	SourceUpstream    = "upstream"
+	SourceRateLimited = "rate_limited"
+	SourceRefusedAny  = "refused_any"
+)
+
+// Entry records the outcome of a single query/response cycle.
+type Entry struct {
+	Timestamp time.Time `json:"timestamp"`
+	ClientIP  string    `json:"client_ip"`
+	QName     string    `json:"qname"`
+	QType     string    `json:"qtype"`
+	Rcode     string    `json:"rcode"`
+	Answer    []string  `json:"answer,omitempty"`
+	ElapsedMs float64   `json:"elapsed_ms"`
+	Upstream  string    `json:"upstream,omitempty"`
+	Source    string    `json:"source"`
+	Rule      string    `json:"rule,omitempty"`
+}
@@ -0,0 +1,206 @@
+// Package querylog records per-query DNS activity to a rotating JSON-lines
+// file and keeps a bounded in-memory window of recent entries so an admin
+// API can serve lookups and rolling stats without re-reading disk.
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Recorder records query log entries to per-day JSON-lines files under dir
+// and mirrors them into an in-memory ring buffer of ringCapacity entries.
+type Recorder struct {
+	dir       string
+	retention time.Duration
+
+	mu      sync.Mutex
+	ring    []Entry
+	ringPos int
+	ringLen int
+
+	fileMu   sync.Mutex
+	file     *os.File
+	fileDate string
+}
+
+// NewRecorder returns a Recorder writing JSON-lines files under dir,
+// keeping ringCapacity entries in memory for GetRecent and Stats. Files
+// older than retention are deleted whenever a new day's file is opened;
+// retention <= 0 disables pruning.
+func NewRecorder(dir string, ringCapacity int, retention time.Duration) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("querylog: creating %s: %w", dir, err)
+	}
+	return &Recorder{
+		dir:       dir,
+		retention: retention,
+		ring:      make([]Entry, ringCapacity),
+	}, nil
+}
+
+// Record appends entry to the in-memory ring and the current day's log
+// file. Callers on the query path should do this from a goroutine so
+// logging never adds to query latency.
+func (r *Recorder) Record(entry Entry) {
+	r.mu.Lock()
+	r.ring[r.ringPos] = entry
+	r.ringPos = (r.ringPos + 1) % len(r.ring)
+	if r.ringLen < len(r.ring) {
+		r.ringLen++
+	}
+	r.mu.Unlock()
+
+	if err := r.appendToFile(entry); err != nil {
+		log.Printf("querylog: %v\n", err)
+	}
+}
+
+func (r *Recorder) appendToFile(entry Entry) error {
+	date := entry.Timestamp.Format("2006-01-02")
+
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+
+	if r.file == nil || r.fileDate != date {
+		if r.file != nil {
+			r.file.Close()
+		}
+		f, err := os.OpenFile(filepath.Join(r.dir, "query-"+date+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("querylog: opening log file: %w", err)
+		}
+		r.file = f
+		r.fileDate = date
+		r.pruneOldFiles()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("querylog: marshaling entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	_, err = r.file.Write(data)
+	return err
+}
+
+// pruneOldFiles deletes log files last modified before r.retention ago.
+func (r *Recorder) pruneOldFiles() {
+	if r.retention <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		log.Printf("querylog: listing %s: %v\n", r.dir, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-r.retention)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.dir, e.Name())); err != nil {
+			log.Printf("querylog: removing %s: %v\n", e.Name(), err)
+		}
+	}
+}
+
+// Filter narrows the entries GetRecent returns.
+type Filter struct {
+	Client string
+	Domain string
+	Limit  int
+}
+
+// GetRecent returns entries matching f, most recent first. Limit <= 0
+// returns every matching entry still in the ring buffer.
+func (r *Recorder) GetRecent(f Filter) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := make([]Entry, 0, r.ringLen)
+	for i := 0; i < r.ringLen; i++ {
+		if f.Limit > 0 && len(matched) >= f.Limit {
+			break
+		}
+		idx := (r.ringPos - 1 - i + len(r.ring)) % len(r.ring)
+		entry := r.ring[idx]
+		if f.Client != "" && entry.ClientIP != f.Client {
+			continue
+		}
+		if f.Domain != "" && entry.QName != f.Domain {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+	return matched
+}
+
+// Count is a (key, count) pair used for Stats' top-N breakdowns.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Stats summarizes query volume over a trailing window.
+type Stats struct {
+	TopClients []Count `json:"top_clients"`
+	TopDomains []Count `json:"top_domains"`
+	Blocked    int     `json:"blocked"`
+	Total      int     `json:"total"`
+}
+
+// Stats aggregates the ring buffer's entries timestamped within the last
+// window, returning the topN most frequent clients and domains.
+func (r *Recorder) Stats(window time.Duration, topN int) Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	clientCounts := make(map[string]int)
+	domainCounts := make(map[string]int)
+	blocked, total := 0, 0
+
+	for i := 0; i < r.ringLen; i++ {
+		idx := (r.ringPos - 1 - i + len(r.ring)) % len(r.ring)
+		entry := r.ring[idx]
+		if entry.Timestamp.Before(cutoff) {
+			continue
+		}
+		clientCounts[entry.ClientIP]++
+		domainCounts[entry.QName]++
+		total++
+		if entry.Source == SourceBlocked {
+			blocked++
+		}
+	}
+
+	return Stats{
+		TopClients: topCounts(clientCounts, topN),
+		TopDomains: topCounts(domainCounts, topN),
+		Blocked:    blocked,
+		Total:      total,
+	}
+}
+
+func topCounts(counts map[string]int, topN int) []Count {
+	all := make([]Count, 0, len(counts))
+	for k, v := range counts {
+		all = append(all, Count{Key: k, Count: v})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Count > all[j].Count })
+	if topN > 0 && len(all) > topN {
+		all = all[:topN]
+	}
+	return all
+}
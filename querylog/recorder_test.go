@@ -0,0 +1,121 @@
+package querylog
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderGetRecentMostRecentFirst(t *testing.T) {
+	r, err := NewRecorder(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	r.Record(Entry{Timestamp: time.Now(), ClientIP: "192.0.2.1", QName: "first.example.com.", Source: SourceUpstream})
+	r.Record(Entry{Timestamp: time.Now(), ClientIP: "192.0.2.1", QName: "second.example.com.", Source: SourceUpstream})
+
+	got := r.GetRecent(Filter{})
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].QName != "second.example.com." {
+		t.Errorf("got[0].QName = %q, want most recent entry first", got[0].QName)
+	}
+}
+
+func TestRecorderGetRecentFiltersByClientAndDomain(t *testing.T) {
+	r, err := NewRecorder(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	r.Record(Entry{Timestamp: time.Now(), ClientIP: "192.0.2.1", QName: "a.example.com.", Source: SourceUpstream})
+	r.Record(Entry{Timestamp: time.Now(), ClientIP: "192.0.2.2", QName: "b.example.com.", Source: SourceUpstream})
+
+	byClient := r.GetRecent(Filter{Client: "192.0.2.2"})
+	if len(byClient) != 1 || byClient[0].ClientIP != "192.0.2.2" {
+		t.Fatalf("GetRecent(Client) = %v, want a single entry for 192.0.2.2", byClient)
+	}
+
+	byDomain := r.GetRecent(Filter{Domain: "a.example.com."})
+	if len(byDomain) != 1 || byDomain[0].QName != "a.example.com." {
+		t.Fatalf("GetRecent(Domain) = %v, want a single entry for a.example.com.", byDomain)
+	}
+}
+
+func TestRecorderGetRecentRespectsRingCapacity(t *testing.T) {
+	r, err := NewRecorder(t.TempDir(), 2, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	r.Record(Entry{Timestamp: time.Now(), QName: "one.example.com.", Source: SourceUpstream})
+	r.Record(Entry{Timestamp: time.Now(), QName: "two.example.com.", Source: SourceUpstream})
+	r.Record(Entry{Timestamp: time.Now(), QName: "three.example.com.", Source: SourceUpstream})
+
+	got := r.GetRecent(Filter{})
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2 (ring capacity should evict the oldest)", len(got))
+	}
+	if got[0].QName != "three.example.com." || got[1].QName != "two.example.com." {
+		t.Fatalf("got %v, want [three, two] (one.example.com. should have been evicted)", got)
+	}
+}
+
+func TestRecorderStatsCountsWithinWindow(t *testing.T) {
+	r, err := NewRecorder(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	now := time.Now()
+	r.Record(Entry{Timestamp: now, ClientIP: "192.0.2.1", QName: "blocked.example.com.", Source: SourceBlocked})
+	r.Record(Entry{Timestamp: now, ClientIP: "192.0.2.1", QName: "allowed.example.com.", Source: SourceUpstream})
+	r.Record(Entry{Timestamp: now.Add(-2 * time.Hour), ClientIP: "192.0.2.1", QName: "stale.example.com.", Source: SourceUpstream})
+
+	stats := r.Stats(time.Hour, 10)
+	if stats.Total != 2 {
+		t.Errorf("Total = %d, want 2 (the stale entry is outside the window)", stats.Total)
+	}
+	if stats.Blocked != 1 {
+		t.Errorf("Blocked = %d, want 1", stats.Blocked)
+	}
+	if len(stats.TopClients) != 1 || stats.TopClients[0].Count != 2 {
+		t.Errorf("TopClients = %v, want one client with count 2", stats.TopClients)
+	}
+}
+
+func TestRecorderWritesJSONLinesToDisk(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(dir, 10, 0)
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+
+	entry := Entry{Timestamp: time.Now(), ClientIP: "192.0.2.1", QName: "example.com.", Source: SourceUpstream}
+	r.Record(entry)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "query-*.jsonl"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("got %d log files, want 1", len(matches))
+	}
+
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	var got Entry
+	if err := json.Unmarshal(data[:len(data)-1], &got); err != nil { // trim the trailing newline
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if got.QName != entry.QName {
+		t.Errorf("logged QName = %q, want %q", got.QName, entry.QName)
+	}
+}
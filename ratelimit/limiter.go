@@ -0,0 +1,151 @@
+// Package ratelimit implements a per-client-IP token bucket used to guard
+// a DNS server against abusive query volume, with a CIDR allowlist for
+// trusted networks that should never be throttled.
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Defaults matching typical recursive-resolver guidance: generous enough
+// for a busy LAN client, tight enough to blunt a single abusive source.
+const (
+	DefaultQPS   = 50
+	DefaultBurst = 100
+)
+
+// shardCount bounds lock contention across concurrent clients; each shard
+// owns a disjoint slice of the keyspace under its own mutex.
+const shardCount = 32
+
+// bucket is a single client's token bucket.
+type bucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	lastSeen time.Time
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// Limiter rate-limits queries per client IP using a sharded map of token
+// buckets.
+type Limiter struct {
+	qps   float64
+	burst float64
+
+	allow []*net.IPNet
+
+	shards [shardCount]shard
+}
+
+// NewLimiter returns a Limiter allowing qps queries/sec per client IP, with
+// bursts up to burst tokens. Clients inside any of allowCIDRs are never
+// limited.
+func NewLimiter(qps, burst int, allowCIDRs []string) (*Limiter, error) {
+	l := &Limiter{qps: float64(qps), burst: float64(burst)}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*bucket)
+	}
+
+	for _, cidr := range allowCIDRs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit: parsing allow CIDR %q: %w", cidr, err)
+		}
+		l.allow = append(l.allow, ipnet)
+	}
+
+	return l, nil
+}
+
+// Allow reports whether a query from clientIP may proceed, consuming one
+// token if so. A nil clientIP (the address couldn't be parsed) is always
+// allowed, since it can't be attributed to a bucket.
+func (l *Limiter) Allow(clientIP net.IP) bool {
+	if clientIP == nil {
+		return true
+	}
+	for _, ipnet := range l.allow {
+		if ipnet.Contains(clientIP) {
+			return true
+		}
+	}
+
+	b := l.bucketFor(clientIP.String())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.lastSeen).Seconds() * l.qps
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *Limiter) bucketFor(key string) *bucket {
+	s := &l.shards[shardIndex(key)]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastSeen: time.Now()}
+		s.buckets[key] = b
+	}
+	return b
+}
+
+// shardIndex hashes key (FNV-1a) to pick a shard.
+func shardIndex(key string) int {
+	var h uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % shardCount)
+}
+
+// GC removes buckets idle for longer than idleTTL, bounding the map's
+// long-run memory use.
+func (l *Limiter) GC(idleTTL time.Duration) {
+	cutoff := time.Now().Add(-idleTTL)
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.Lock()
+		for key, b := range s.buckets {
+			b.mu.Lock()
+			idle := b.lastSeen.Before(cutoff)
+			b.mu.Unlock()
+			if idle {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// StartGC runs GC every interval, for the life of the program.
+func (l *Limiter) StartGC(interval, idleTTL time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			l.GC(idleTTL)
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowsUpToBurstThenBlocks(t *testing.T) {
+	l, err := NewLimiter(1, 3, nil)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	ip := net.ParseIP("203.0.113.1")
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow(ip) {
+			t.Fatalf("query %d should be allowed within burst", i)
+		}
+	}
+	if l.Allow(ip) {
+		t.Fatal("query beyond burst should be blocked")
+	}
+}
+
+func TestLimiterRefillsOverTime(t *testing.T) {
+	l, err := NewLimiter(100, 1, nil)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	ip := net.ParseIP("203.0.113.2")
+
+	if !l.Allow(ip) {
+		t.Fatal("first query should be allowed")
+	}
+	if l.Allow(ip) {
+		t.Fatal("second immediate query should be blocked")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if !l.Allow(ip) {
+		t.Fatal("query after refill window should be allowed")
+	}
+}
+
+func TestLimiterExemptsAllowedCIDRs(t *testing.T) {
+	l, err := NewLimiter(1, 1, []string{"192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	ip := net.ParseIP("192.168.1.5")
+
+	for i := 0; i < 5; i++ {
+		if !l.Allow(ip) {
+			t.Fatalf("query %d from an allowlisted CIDR should never be blocked", i)
+		}
+	}
+}
+
+func TestLimiterAllowsNilClientIP(t *testing.T) {
+	l, err := NewLimiter(1, 1, nil)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	if !l.Allow(nil) {
+		t.Fatal("a nil client IP should never be blocked, since it can't be attributed to a bucket")
+	}
+}
+
+func TestLimiterTracksClientsIndependently(t *testing.T) {
+	l, err := NewLimiter(1, 1, nil)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	a := net.ParseIP("203.0.113.10")
+	b := net.ParseIP("203.0.113.11")
+
+	if !l.Allow(a) {
+		t.Fatal("client a's first query should be allowed")
+	}
+	if l.Allow(a) {
+		t.Fatal("client a's second immediate query should be blocked")
+	}
+	if !l.Allow(b) {
+		t.Fatal("client b should have its own bucket and not be blocked by a's usage")
+	}
+}
+
+func TestNewLimiterRejectsInvalidCIDR(t *testing.T) {
+	if _, err := NewLimiter(1, 1, []string{"not-a-cidr"}); err == nil {
+		t.Fatal("expected an error for an invalid allow CIDR")
+	}
+}
+
+func TestGCRemovesIdleBuckets(t *testing.T) {
+	l, err := NewLimiter(1, 1, nil)
+	if err != nil {
+		t.Fatalf("NewLimiter: %v", err)
+	}
+	ip := net.ParseIP("203.0.113.20")
+	l.Allow(ip)
+
+	l.GC(-time.Second) // every bucket is "idle" relative to a negative TTL
+
+	if !l.Allow(ip) {
+		t.Fatal("after GC evicts the bucket, a fresh one should start with a full burst")
+	}
+}
@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// bootstrapResolver is a plain DNS resolver used to turn the hostname in a
+// tls://, https://, or quic:// upstream URL into an IP address once on
+// startup. Results are cached for the lifetime of the process so the
+// encrypted transports never need to fall back to the system resolver.
+type bootstrapResolver struct {
+	server string // plain UDP DNS server, e.g. "8.8.8.8:53"
+
+	mu    sync.RWMutex
+	cache map[string]string
+}
+
+// NewBootstrap returns a Bootstrap backed by the plain DNS server at addr.
+func NewBootstrap(addr string) Bootstrap {
+	return &bootstrapResolver{
+		server: addr,
+		cache:  make(map[string]string),
+	}
+}
+
+func (b *bootstrapResolver) Lookup(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return host, nil
+	}
+
+	b.mu.RLock()
+	if ip, ok := b.cache[host]; ok {
+		b.mu.RUnlock()
+		return ip, nil
+	}
+	b.mu.RUnlock()
+
+	ip, err := b.resolve(host)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	b.cache[host] = ip
+	b.mu.Unlock()
+
+	return ip, nil
+}
+
+func (b *bootstrapResolver) resolve(host string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+
+	c := &dns.Client{Net: "udp", DialTimeout: DefaultTimeout, ReadTimeout: DefaultTimeout}
+	reply, _, err := c.Exchange(m, b.server)
+	if err != nil {
+		return "", fmt.Errorf("bootstrap: resolving %q via %s: %w", host, b.server, err)
+	}
+
+	for _, rr := range reply.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			return a.A.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("bootstrap: no A record for %q from %s", host, b.server)
+}
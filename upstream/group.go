@@ -0,0 +1,156 @@
+package upstream
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Strategy selects how a Group spreads queries across its upstreams.
+type Strategy int
+
+const (
+	// RoundRobin sends each query to the next healthy upstream in turn.
+	RoundRobin Strategy = iota
+	// Parallel sends each query to every healthy upstream at once and
+	// returns the first successful reply.
+	Parallel
+)
+
+// healthCheckQuery is used to probe an upstream on startup; NS queries for
+// the root zone are answered by virtually every resolver.
+var healthCheckQuery = func() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	return m
+}
+
+// Group fans a query out to a configurable set of upstreams according to a
+// Strategy, skipping upstreams that failed their startup health check.
+type Group struct {
+	strategy Strategy
+
+	mu      sync.RWMutex
+	healthy []Upstream
+
+	counter uint64
+}
+
+// NewGroup builds a Group from upstreams, health-checking each one and
+// dropping those that don't respond before the group is used. At least one
+// upstream must pass the health check.
+func NewGroup(upstreams []Upstream, strategy Strategy) (*Group, error) {
+	g := &Group{strategy: strategy}
+
+	healthy := checkHealth(upstreams)
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("upstream: no upstream passed its startup health check")
+	}
+	g.healthy = healthy
+
+	return g, nil
+}
+
+// Reconfigure health-checks upstreams and, if at least one passes, swaps
+// them in as the group's new healthy set. The group keeps serving queries
+// with its current upstreams until the new set is ready.
+func (g *Group) Reconfigure(upstreams []Upstream) error {
+	healthy := checkHealth(upstreams)
+	if len(healthy) == 0 {
+		return fmt.Errorf("upstream: no upstream passed its health check")
+	}
+
+	g.mu.Lock()
+	g.healthy = healthy
+	g.mu.Unlock()
+
+	return nil
+}
+
+func checkHealth(upstreams []Upstream) []Upstream {
+	var healthy []Upstream
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, u := range upstreams {
+		wg.Add(1)
+		go func(u Upstream) {
+			defer wg.Done()
+			if _, err := u.Exchange(healthCheckQuery()); err != nil {
+				log.Printf("upstream: %s failed health check: %v", u, err)
+				return
+			}
+			mu.Lock()
+			healthy = append(healthy, u)
+			mu.Unlock()
+		}(u)
+	}
+
+	wg.Wait()
+	return healthy
+}
+
+// Exchange sends m to one or more of the group's healthy upstreams
+// according to its Strategy, returning the upstream that produced the
+// reply.
+func (g *Group) Exchange(m *dns.Msg) (*dns.Msg, Upstream, error) {
+	g.mu.RLock()
+	healthy := g.healthy
+	g.mu.RUnlock()
+
+	if len(healthy) == 0 {
+		return nil, nil, fmt.Errorf("upstream: no healthy upstream available")
+	}
+
+	switch g.strategy {
+	case Parallel:
+		return g.exchangeParallel(healthy, m)
+	default:
+		return g.exchangeRoundRobin(healthy, m)
+	}
+}
+
+func (g *Group) exchangeRoundRobin(healthy []Upstream, m *dns.Msg) (*dns.Msg, Upstream, error) {
+	idx := atomic.AddUint64(&g.counter, 1)
+	u := healthy[idx%uint64(len(healthy))]
+	reply, err := u.Exchange(m)
+	if err != nil {
+		return nil, u, fmt.Errorf("upstream %s: %w", u, err)
+	}
+	return reply, u, nil
+}
+
+func (g *Group) exchangeParallel(healthy []Upstream, m *dns.Msg) (*dns.Msg, Upstream, error) {
+	type result struct {
+		reply *dns.Msg
+		u     Upstream
+		err   error
+	}
+
+	results := make(chan result, len(healthy))
+	for _, u := range healthy {
+		go func(u Upstream) {
+			reply, err := u.Exchange(m)
+			results <- result{reply: reply, u: u, err: err}
+		}(u)
+	}
+
+	var lastErr error
+	for range healthy {
+		select {
+		case r := <-results:
+			if r.err == nil {
+				return r.reply, r.u, nil
+			}
+			lastErr = r.err
+		case <-time.After(DefaultTimeout):
+			return nil, nil, fmt.Errorf("upstream: all queries timed out")
+		}
+	}
+
+	return nil, nil, fmt.Errorf("upstream: all upstreams failed, last error: %w", lastErr)
+}
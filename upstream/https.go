@@ -0,0 +1,96 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/miekg/dns"
+)
+
+// dohContentType is the RFC 8484 media type for wire-format DNS messages.
+const dohContentType = "application/dns-message"
+
+// httpsUpstream forwards queries over DNS-over-HTTPS (RFC 8484) using POST.
+type httpsUpstream struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newHTTPSUpstream(u *url.URL, bootstrap Bootstrap) (*httpsUpstream, error) {
+	host := u.Hostname()
+
+	dialHost := host
+	if bootstrap != nil {
+		ip, err := bootstrap.Lookup(host)
+		if err != nil {
+			return nil, fmt.Errorf("https upstream %q: %w", u, err)
+		}
+		dialHost = ip
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	dialAddr := net.JoinHostPort(dialHost, port)
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// Dial the bootstrapped IP directly; TLS verification still
+			// checks the certificate against the original hostname below.
+			return (&net.Dialer{Timeout: DefaultTimeout}).DialContext(ctx, network, dialAddr)
+		},
+		TLSClientConfig: &tls.Config{ServerName: host},
+	}
+
+	return &httpsUpstream{
+		endpoint: u.String(),
+		client:   &http.Client{Transport: transport, Timeout: DefaultTimeout},
+	}, nil
+}
+
+func (h *httpsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("https upstream: packing query: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("https upstream: building request: %w", err)
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("https upstream %s: %w", h.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("https upstream %s: unexpected status %d", h.endpoint, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("https upstream %s: reading response: %w", h.endpoint, err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("https upstream %s: unpacking response: %w", h.endpoint, err)
+	}
+
+	return reply, nil
+}
+
+func (h *httpsUpstream) String() string {
+	return h.endpoint
+}
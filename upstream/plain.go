@@ -0,0 +1,30 @@
+package upstream
+
+import (
+	"github.com/miekg/dns"
+)
+
+// plainUpstream forwards queries over classic UDP or TCP DNS.
+type plainUpstream struct {
+	net  string // "udp" or "tcp"
+	addr string
+}
+
+func newPlainUpstream(net, addr string) *plainUpstream {
+	return &plainUpstream{net: net, addr: addr}
+}
+
+func (p *plainUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:          p.net,
+		DialTimeout:  DefaultTimeout,
+		ReadTimeout:  DefaultTimeout,
+		WriteTimeout: DefaultTimeout,
+	}
+	reply, _, err := c.Exchange(m, p.addr)
+	return reply, err
+}
+
+func (p *plainUpstream) String() string {
+	return p.net + "://" + p.addr
+}
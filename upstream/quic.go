@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqALPN is the ALPN token for DNS-over-QUIC, RFC 9250 section 4.1.1.
+var doqALPN = []string{"doq"}
+
+// quicUpstream forwards queries over DNS-over-QUIC (RFC 9250). Each query
+// is sent on its own bidirectional stream, framed like DNS-over-TCP with a
+// two-byte length prefix. The underlying QUIC connection is cached and
+// reused across queries, and transparently re-dialed once it's no longer
+// alive, so a new handshake isn't paid on every call.
+type quicUpstream struct {
+	serverName string
+	dialAddr   string
+	tlsConfig  *tls.Config
+
+	mu   sync.Mutex
+	conn quic.Connection
+}
+
+func newQUICUpstream(u *url.URL, bootstrap Bootstrap) (*quicUpstream, error) {
+	host, port, err := splitHostPort(u, "853")
+	if err != nil {
+		return nil, err
+	}
+
+	dialHost := host
+	if bootstrap != nil {
+		ip, err := bootstrap.Lookup(host)
+		if err != nil {
+			return nil, fmt.Errorf("quic upstream %q: %w", u, err)
+		}
+		dialHost = ip
+	}
+
+	return &quicUpstream{
+		serverName: host,
+		dialAddr:   net.JoinHostPort(dialHost, port),
+		tlsConfig:  &tls.Config{ServerName: host, NextProtos: doqALPN},
+	}, nil
+}
+
+func (q *quicUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	conn, err := q.connection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("quic upstream %s: dial: %w", q.dialAddr, err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("quic upstream %s: open stream: %w", q.dialAddr, err)
+	}
+	defer stream.Close()
+
+	// DoQ requires query IDs to be set to zero on the wire.
+	query := m.Copy()
+	query.Id = 0
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("quic upstream: packing query: %w", err)
+	}
+
+	framed := append([]byte{byte(len(packed) >> 8), byte(len(packed))}, packed...)
+	if _, err := stream.Write(framed); err != nil {
+		return nil, fmt.Errorf("quic upstream %s: write: %w", q.dialAddr, err)
+	}
+	stream.Close() // signal end of request per RFC 9250 section 4.2
+
+	lengthPrefix := make([]byte, 2)
+	if _, err := readFull(stream, lengthPrefix); err != nil {
+		return nil, fmt.Errorf("quic upstream %s: read length: %w", q.dialAddr, err)
+	}
+
+	respLen := int(lengthPrefix[0])<<8 | int(lengthPrefix[1])
+	respBuf := make([]byte, respLen)
+	if _, err := readFull(stream, respBuf); err != nil {
+		return nil, fmt.Errorf("quic upstream %s: read response: %w", q.dialAddr, err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(respBuf); err != nil {
+		return nil, fmt.Errorf("quic upstream %s: unpacking response: %w", q.dialAddr, err)
+	}
+	reply.Id = m.Id
+
+	return reply, nil
+}
+
+// connection returns the cached QUIC connection, re-dialing if there isn't
+// one yet or the cached one has gone dead (idle timeout, peer reset, etc).
+func (q *quicUpstream) connection(ctx context.Context) (quic.Connection, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.conn != nil && q.conn.Context().Err() == nil {
+		return q.conn, nil
+	}
+
+	conn, err := quic.DialAddr(ctx, q.dialAddr, q.tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	q.conn = conn
+	return conn, nil
+}
+
+func (q *quicUpstream) String() string {
+	return "quic://" + q.serverName
+}
+
+func readFull(r interface{ Read([]byte) (int, error) }, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := r.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/miekg/dns"
+)
+
+// tlsUpstream forwards queries over DNS-over-TLS (RFC 7858).
+type tlsUpstream struct {
+	serverName string // for SNI / certificate verification
+	dialAddr   string // host:port to dial, pre-resolved via bootstrap
+}
+
+func newTLSUpstream(u *url.URL, bootstrap Bootstrap) (*tlsUpstream, error) {
+	host, port, err := splitHostPort(u, "853")
+	if err != nil {
+		return nil, err
+	}
+
+	dialHost := host
+	if bootstrap != nil {
+		ip, err := bootstrap.Lookup(host)
+		if err != nil {
+			return nil, fmt.Errorf("tls upstream %q: %w", u, err)
+		}
+		dialHost = ip
+	}
+
+	return &tlsUpstream{
+		serverName: host,
+		dialAddr:   net.JoinHostPort(dialHost, port),
+	}, nil
+}
+
+func (t *tlsUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:          "tcp-tls",
+		TLSConfig:    &tls.Config{ServerName: t.serverName},
+		DialTimeout:  DefaultTimeout,
+		ReadTimeout:  DefaultTimeout,
+		WriteTimeout: DefaultTimeout,
+	}
+	reply, _, err := c.Exchange(m, t.dialAddr)
+	return reply, err
+}
+
+func (t *tlsUpstream) String() string {
+	return "tls://" + t.serverName
+}
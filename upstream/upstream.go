@@ -0,0 +1,58 @@
+// Package upstream provides pluggable transports for forwarding DNS queries
+// to recursive/forwarding upstream servers, including the encrypted
+// DoT/DoH/DoQ transports in addition to plain UDP/TCP.
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTimeout is used for dialing and exchanging with every upstream.
+const DefaultTimeout = 5 * time.Second
+
+// Upstream sends a DNS query to a single configured server and returns its
+// reply. Implementations must be safe for concurrent use.
+type Upstream interface {
+	// Exchange sends m and returns the upstream's reply.
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+	// String identifies the upstream, e.g. "udp://8.8.8.8:53", for logging.
+	String() string
+}
+
+// Bootstrap resolves the hostnames found in tls:// and https:// upstream URLs
+// to IP addresses, so the encrypted transports can dial straight to an IP
+// instead of depending on the system resolver.
+type Bootstrap interface {
+	// Lookup returns an IP address for host, consulting a cache before
+	// querying the bootstrap resolver.
+	Lookup(host string) (string, error)
+}
+
+// New parses rawURL and returns the Upstream implementation matching its
+// scheme: udp://, tcp://, tls://, https://, or quic://. bootstrap may be nil
+// for udp/tcp upstreams, which dial the address as given.
+func New(rawURL string, bootstrap Bootstrap) (Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: invalid URL %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return newPlainUpstream("udp", u.Host), nil
+	case "tcp":
+		return newPlainUpstream("tcp", u.Host), nil
+	case "tls":
+		return newTLSUpstream(u, bootstrap)
+	case "https":
+		return newHTTPSUpstream(u, bootstrap)
+	case "quic":
+		return newQUICUpstream(u, bootstrap)
+	default:
+		return nil, fmt.Errorf("upstream: unsupported scheme %q in %q", u.Scheme, rawURL)
+	}
+}
@@ -0,0 +1,391 @@
+package upstream
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// echoHandler answers every query with a single A record for the question
+// name, so tests can assert the round trip worked without caring about the
+// answer's content.
+func echoHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if len(r.Question) == 1 {
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+		m.Answer = []dns.RR{rr}
+	}
+	w.WriteMsg(m)
+}
+
+func query(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return m
+}
+
+func assertEchoedA(t *testing.T, reply *dns.Msg, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if len(reply.Answer) != 1 {
+		t.Fatalf("got %d answers, want 1", len(reply.Answer))
+	}
+	if _, ok := reply.Answer[0].(*dns.A); !ok {
+		t.Fatalf("answer is %T, want *dns.A", reply.Answer[0])
+	}
+}
+
+func TestPlainUpstreamUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(echoHandler)}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	u := newPlainUpstream("udp", pc.LocalAddr().String())
+	reply, err := u.Exchange(query("example.com."))
+	assertEchoedA(t, reply, err)
+
+	if got, want := u.String(), "udp://"+pc.LocalAddr().String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestPlainUpstreamTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := &dns.Server{Listener: ln, Handler: dns.HandlerFunc(echoHandler)}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	u := newPlainUpstream("tcp", ln.Addr().String())
+	reply, err := u.Exchange(query("example.com."))
+	assertEchoedA(t, reply, err)
+}
+
+func TestBootstrapResolverLookup(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 203.0.113.9")
+		m.Answer = []dns.RR{rr}
+		w.WriteMsg(m)
+	})}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	b := NewBootstrap(pc.LocalAddr().String())
+
+	ip, err := b.Lookup("resolver.example.com")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if ip != "203.0.113.9" {
+		t.Fatalf("Lookup() = %q, want 203.0.113.9", ip)
+	}
+
+	// A literal IP is returned as-is, without consulting the bootstrap server.
+	if ip, err := b.Lookup("198.51.100.1"); err != nil || ip != "198.51.100.1" {
+		t.Fatalf("Lookup(IP literal) = (%q, %v)", ip, err)
+	}
+}
+
+// selfSignedCert generates an in-memory TLS certificate valid for host, for
+// tests that need a local TLS listener without touching disk.
+func selfSignedCert(t *testing.T, host string) tls.Certificate {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{host},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	cert, err := tls.X509KeyPair(pemEncode("CERTIFICATE", der), pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+	if err != nil {
+		t.Fatalf("X509KeyPair: %v", err)
+	}
+	return cert
+}
+
+func pemEncode(blockType string, der []byte) []byte {
+	block := &pemBlock{Type: blockType, Bytes: der}
+	return block.encode()
+}
+
+// pemBlock is a tiny PEM encoder so the test has no extra stdlib import
+// beyond what's already pulled in above.
+type pemBlock struct {
+	Type  string
+	Bytes []byte
+}
+
+func (b *pemBlock) encode() []byte {
+	var out []byte
+	out = append(out, []byte("-----BEGIN "+b.Type+"-----\n")...)
+	enc := encodeBase64(b.Bytes)
+	for len(enc) > 64 {
+		out = append(out, enc[:64]...)
+		out = append(out, '\n')
+		enc = enc[64:]
+	}
+	out = append(out, enc...)
+	out = append(out, '\n')
+	out = append(out, []byte("-----END "+b.Type+"-----\n")...)
+	return out
+}
+
+func encodeBase64(der []byte) []byte {
+	const tbl = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	var out []byte
+	for i := 0; i < len(der); i += 3 {
+		end := i + 3
+		if end > len(der) {
+			end = len(der)
+		}
+		chunk := der[i:end]
+		var n int
+		for _, b := range chunk {
+			n = n<<8 | int(b)
+		}
+		n <<= uint(8 * (3 - len(chunk)))
+		for j := 0; j < 4; j++ {
+			if j > len(chunk) {
+				out = append(out, '=')
+				continue
+			}
+			out = append(out, tbl[(n>>uint(18-6*j))&0x3f])
+		}
+	}
+	return out
+}
+
+// exchangeOverTLS dials addr with serverName verified against a pool trusting
+// cert, replicating tlsUpstream.Exchange's dns.Client setup. tlsUpstream
+// itself always verifies against the system root pool, so tests supply their
+// own trusted pool here to exercise the wire protocol against a local
+// self-signed listener.
+func exchangeOverTLS(t *testing.T, addr, serverName string, cert tls.Certificate, m *dns.Msg) (*dns.Msg, error) {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	pool.AddCert(leaf)
+
+	c := &dns.Client{
+		Net:          "tcp-tls",
+		TLSConfig:    &tls.Config{ServerName: serverName, RootCAs: pool},
+		DialTimeout:  DefaultTimeout,
+		ReadTimeout:  DefaultTimeout,
+		WriteTimeout: DefaultTimeout,
+	}
+	reply, _, err := c.Exchange(m, addr)
+	return reply, err
+}
+
+type testBootstrap struct{ ip string }
+
+func (b testBootstrap) Lookup(string) (string, error) { return b.ip, nil }
+
+func TestTLSUpstreamResolvesDialAddrViaBootstrap(t *testing.T) {
+	cert := selfSignedCert(t, "dot.test")
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	srv := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: dns.HandlerFunc(echoHandler)}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("SplitHostPort: %v", err)
+	}
+
+	u, err := url.Parse("tls://dot.test:" + port)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	tlsU, err := newTLSUpstream(u, testBootstrap{ip: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("newTLSUpstream: %v", err)
+	}
+
+	if tlsU.serverName != "dot.test" {
+		t.Errorf("serverName = %q, want dot.test", tlsU.serverName)
+	}
+	if want := net.JoinHostPort("127.0.0.1", port); tlsU.dialAddr != want {
+		t.Errorf("dialAddr = %q, want %q (bootstrap should resolve the hostname, not dial it literally)", tlsU.dialAddr, want)
+	}
+
+	reply, err := exchangeOverTLS(t, tlsU.dialAddr, tlsU.serverName, cert, query("example.com."))
+	assertEchoedA(t, reply, err)
+}
+
+func TestHTTPSUpstreamDoH(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reply := new(dns.Msg)
+		reply.SetReply(query)
+		if len(query.Question) == 1 {
+			rr, _ := dns.NewRR(query.Question[0].Name + " 60 IN A 192.0.2.1")
+			reply.Answer = []dns.RR{rr}
+		}
+		packed, err := reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(packed)
+	}))
+	defer ts.Close()
+
+	endpoint, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+
+	u, err := newHTTPSUpstream(endpoint, nil)
+	if err != nil {
+		t.Fatalf("newHTTPSUpstream: %v", err)
+	}
+	// ts's client trusts ts's self-signed certificate; newHTTPSUpstream's own
+	// transport only trusts the system pool, so swap it in for the test.
+	u.client = ts.Client()
+
+	reply, err := u.Exchange(query("example.com."))
+	assertEchoedA(t, reply, err)
+}
+
+type fakeUpstream struct {
+	name string
+	fail bool
+}
+
+func (f *fakeUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if f.fail {
+		return nil, fmt.Errorf("fake upstream %s is down", f.name)
+	}
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	return reply, nil
+}
+
+func (f *fakeUpstream) String() string { return f.name }
+
+func TestGroupRoundRobinSpreadsAcrossUpstreams(t *testing.T) {
+	a := &fakeUpstream{name: "a"}
+	b := &fakeUpstream{name: "b"}
+
+	g, err := NewGroup([]Upstream{a, b}, RoundRobin)
+	if err != nil {
+		t.Fatalf("NewGroup: %v", err)
+	}
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		_, used, err := g.Exchange(query("example.com."))
+		if err != nil {
+			t.Fatalf("Exchange: %v", err)
+		}
+		seen[used.String()]++
+	}
+	if seen["a"] == 0 || seen["b"] == 0 {
+		t.Fatalf("round-robin didn't use both upstreams: %v", seen)
+	}
+}
+
+func TestGroupParallelReturnsFirstSuccess(t *testing.T) {
+	slow := &fakeUpstream{name: "slow"}
+	fast := &fakeUpstream{name: "fast"}
+
+	g, err := NewGroup([]Upstream{slow, fast}, Parallel)
+	if err != nil {
+		t.Fatalf("NewGroup: %v", err)
+	}
+
+	reply, _, err := g.Exchange(query("example.com."))
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if reply == nil {
+		t.Fatal("Exchange returned a nil reply")
+	}
+}
+
+func TestNewGroupDropsUpstreamsThatFailHealthCheck(t *testing.T) {
+	healthy := &fakeUpstream{name: "healthy"}
+	unhealthy := &fakeUpstream{name: "unhealthy", fail: true}
+
+	g, err := NewGroup([]Upstream{healthy, unhealthy}, RoundRobin)
+	if err != nil {
+		t.Fatalf("NewGroup: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, used, err := g.Exchange(query("example.com."))
+		if err != nil {
+			t.Fatalf("Exchange: %v", err)
+		}
+		if used.String() != "healthy" {
+			t.Fatalf("Exchange used %q, want only the healthy upstream", used.String())
+		}
+	}
+}
+
+func TestNewGroupErrorsWhenAllUpstreamsFailHealthCheck(t *testing.T) {
+	_, err := NewGroup([]Upstream{&fakeUpstream{name: "down", fail: true}}, RoundRobin)
+	if err == nil {
+		t.Fatal("expected an error when no upstream passes its health check")
+	}
+}
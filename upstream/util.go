@@ -0,0 +1,16 @@
+package upstream
+
+import (
+	"net/url"
+)
+
+// splitHostPort returns the host and port from u, falling back to
+// defaultPort when the URL does not specify one.
+func splitHostPort(u *url.URL, defaultPort string) (host, port string, err error) {
+	host = u.Hostname()
+	port = u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return host, port, nil
+}